@@ -0,0 +1,144 @@
+package sshclient
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxListedTargets is how many hosts an output group names before it only
+// counts the rest.
+const maxListedTargets = 10
+
+// addrPattern matches IP addresses with an optional port, such as the
+// local and remote addresses in "read tcp 10.0.0.1:51234->10.0.0.5:22".
+var addrPattern = regexp.MustCompile(`\[[0-9A-Fa-f:.%]+\](:\d+)?|\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`)
+
+// OutputGroup is a set of hosts that produced the same normalized output
+// and exit code. Error is set for hosts where the operation did not run to
+// completion, such as connection failures.
+type OutputGroup struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Error    string
+	Results  []CommandResult
+}
+
+func (g OutputGroup) Targets() []string {
+	targets := []string{}
+	for _, result := range g.Results {
+		targets = append(targets, result.Target)
+	}
+	return targets
+}
+
+// OutputReport is the outcome of a fan-out with identical outputs merged,
+// largest group first.
+type OutputReport struct {
+	Groups  []OutputGroup
+	Summary GroupSummary
+}
+
+// NormalizeOutput is the default normalization of AggregateOutput: line
+// endings become "\n", trailing spaces are removed from every line and
+// blank lines are trimmed from both ends.
+func NormalizeOutput(output string) string {
+	lines := strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+// AggregateOutput groups results by normalized stdout, stderr, exit code
+// and error, with host names and addresses removed from errors so that all
+// unreachable hosts form one group. normalize defaults to NormalizeOutput; a custom one can, for
+// example, mask host names or timestamps so that they do not split groups.
+// Groups are ordered by size, then by first appearance.
+func AggregateOutput(results []CommandResult, normalize func(string) string) OutputReport {
+	if normalize == nil {
+		normalize = NormalizeOutput
+	}
+	type outputKey struct {
+		stdout, stderr, err string
+		exitCode            int
+	}
+	groups := []OutputGroup{}
+	index := map[outputKey]int{}
+	for _, result := range results {
+		key := outputKey{
+			stdout:   normalize(result.Stdout),
+			stderr:   normalize(result.Stderr),
+			exitCode: result.ExitCode,
+		}
+		// Exit errors are covered by the exit code; anything else, like a
+		// failed dial, is part of what the hosts have in common.
+		if result.Err != nil && result.ExitCode == -1 {
+			key.err = normalizeError(result)
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, OutputGroup{Stdout: key.stdout, Stderr: key.stderr, ExitCode: key.exitCode, Error: key.err})
+		}
+		groups[i].Results = append(groups[i].Results, result)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].Results) > len(groups[j].Results)
+	})
+	return OutputReport{Groups: groups, Summary: summarize(results)}
+}
+
+func normalizeError(result CommandResult) string {
+	msg := result.Err.Error()
+	if target, err := ParseTarget(result.Target); err == nil && target.Host != "" {
+		msg = strings.ReplaceAll(msg, target.Addr(), "<host>")
+		msg = strings.ReplaceAll(msg, target.Host, "<host>")
+	}
+	return addrPattern.ReplaceAllString(msg, "<host>")
+}
+
+func (r GroupResult) Aggregate() OutputReport {
+	report := AggregateOutput(r.Results, nil)
+	report.Summary = r.Summary
+	return report
+}
+
+// String renders the report: a header per group with its size, exit code
+// and hosts, followed by the output.
+//
+//	==== 180 hosts, exit 0: web001, web002, ... (+170 more)
+//	ok
+//	==== 1 host, exit 3: web113
+//	[stderr]
+//	disk full
+func (r OutputReport) String() string {
+	b := strings.Builder{}
+	for _, group := range r.Groups {
+		hosts := "hosts"
+		if len(group.Results) == 1 {
+			hosts = "host"
+		}
+		status := fmt.Sprintf("exit %d", group.ExitCode)
+		if group.Error != "" {
+			status = "error: " + group.Error
+		}
+		targets := group.Targets()
+		listed := strings.Join(targets[:min(len(targets), maxListedTargets)], ", ")
+		if len(targets) > maxListedTargets {
+			listed += fmt.Sprintf(", ... (+%d more)", len(targets)-maxListedTargets)
+		}
+		fmt.Fprintf(&b, "==== %d %s, %s: %s\n", len(group.Results), hosts, status, listed)
+		if group.Stdout != "" {
+			b.WriteString(group.Stdout + "\n")
+		}
+		if group.Stderr != "" {
+			b.WriteString("[stderr]\n" + group.Stderr + "\n")
+		}
+	}
+	b.WriteString(r.Summary.String() + "\n")
+	return b.String()
+}
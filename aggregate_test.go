@@ -0,0 +1,103 @@
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAggregateOutputGroupsIdenticalOutput(t *testing.T) {
+	results := []CommandResult{}
+	for i := 0; i < 12; i++ {
+		stdout := "ok\n"
+		if i%2 == 1 {
+			stdout = "ok  \r\n\r\n"
+		}
+		results = append(results, CommandResult{Target: fmt.Sprintf("web%02d", i), Stdout: stdout})
+	}
+	results = append(results,
+		CommandResult{Target: "web12", Stderr: "disk full\n", ExitCode: 3, Err: errors.New("Process exited with status 3")},
+		CommandResult{Target: "web13", ExitCode: -1, Err: errors.New("dial tcp 10.0.0.13:22: connect: connection refused")},
+		CommandResult{Target: "web14", ExitCode: -1, Err: errors.New("dial tcp 10.0.0.14:22: connect: connection refused")},
+	)
+
+	report := AggregateOutput(results, nil)
+	if len(report.Groups) != 3 {
+		t.Fatalf("expected 3 groups, got %+v", report.Groups)
+	}
+	majority, refused, failed := report.Groups[0], report.Groups[1], report.Groups[2]
+	if len(majority.Results) != 12 || majority.Stdout != "ok" || majority.ExitCode != 0 {
+		t.Fatalf("unexpected majority group %+v", majority)
+	}
+	if strings.Join(refused.Targets(), ",") != "web13,web14" || refused.Error != "dial tcp <host>: connect: connection refused" {
+		t.Fatalf("unexpected error group %+v", refused)
+	}
+	if failed.ExitCode != 3 || failed.Stderr != "disk full" || failed.Error != "" {
+		t.Fatalf("unexpected failed group %+v", failed)
+	}
+
+	out := report.String()
+	for _, want := range []string{
+		"==== 12 hosts, exit 0: web00, web01, web02, web03, web04, web05, web06, web07, web08, web09, ... (+2 more)\nok\n",
+		"==== 2 hosts, error: dial tcp <host>: connect: connection refused: web13, web14\n",
+		"==== 1 host, exit 3: web12\n[stderr]\ndisk full\n",
+		"15 hosts: 12 ok, 3 failed, 0 skipped",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report is missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestAggregateOutputCustomNormalize(t *testing.T) {
+	results := []CommandResult{
+		{Target: "a", Stdout: "uptime a: 3 days"},
+		{Target: "b", Stdout: "uptime b: 3 days"},
+	}
+	report := AggregateOutput(results, func(output string) string {
+		_, rest, _ := strings.Cut(output, ":")
+		return strings.TrimSpace(rest)
+	})
+	if len(report.Groups) != 1 || report.Groups[0].Stdout != "3 days" {
+		t.Fatalf("unexpected groups %+v", report.Groups)
+	}
+}
+
+func TestAggregateOutputGroupsUnreachableHosts(t *testing.T) {
+	targets := newExecTargets(t, 2)
+	for i := 0; i < 3; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener.Close()
+		targets = append(targets, ClientConfig{User: "test", Host: listener.Addr().String(), SSHFolderPath: t.TempDir()})
+	}
+	for i := 0; i < 2; i++ {
+		// Accepts connections but never speaks SSH.
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		targets = append(targets, ClientConfig{User: "test", Host: listener.Addr().String(), SSHFolderPath: t.TempDir(), HandshakeTimeout: 100 * time.Millisecond})
+	}
+
+	group := &Group{Targets: targets, Options: []ClientOption{WithGlobalKnownHostsFiles()}}
+	report := group.Run(context.Background(), Command("echo ok")).Aggregate()
+	if len(report.Groups) != 3 {
+		t.Fatalf("expected ok, refused and timed out groups, got:\n%s", report)
+	}
+	for i, size := range []int{3, 2, 2} {
+		if len(report.Groups[i].Results) != size {
+			t.Fatalf("group %d has %d hosts, want %d:\n%s", i, len(report.Groups[i].Results), size, report)
+		}
+	}
+	if !strings.Contains(report.Groups[0].Error, "refused") || strings.Contains(report.Groups[0].Error, "127.0.0.1") {
+		t.Fatalf("unexpected error group %q", report.Groups[0].Error)
+	}
+}
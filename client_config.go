@@ -0,0 +1,159 @@
+package sshclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ClientConfig describes one connection. Every setting belongs to the
+// client being dialed; nothing is read from package variables.
+type ClientConfig struct {
+	User string
+	// Host may be any form accepted by ParseTarget; a user or port found
+	// there is used when User or Port is empty.
+	Host string
+	// Port defaults to 22.
+	Port string
+
+	// Auth replaces the default authentication, which tries the private
+	// key files and then Password.
+	Auth     []ssh.AuthMethod
+	Password string
+	// PrivateKeyFile must be readable when set. Otherwise IdentityFiles
+	// are tried, skipping missing ones; they default to id_rsa, id_ecdsa
+	// and id_ed25519 in SSHFolderPath.
+	PrivateKeyFile string
+	IdentityFiles  []string
+
+	// SSHFolderPath defaults to ~/.ssh.
+	SSHFolderPath string
+	// KnownHostsFile defaults to known_hosts in SSHFolderPath.
+	KnownHostsFile string
+	// ExtraKnownHostsFiles are consulted read-only, like the global
+	// known_hosts files; new keys only go to KnownHostsFile.
+	ExtraKnownHostsFiles []string
+	// HostKeyPolicy defaults to HostKeyPolicyAcceptNew.
+	HostKeyPolicy HostKeyPolicy
+
+	// Timeouts for establishing the TCP connection, for the SSH handshake
+	// including authentication, and for opening each session. Zero selects
+	// the package default and a negative value disables the timeout.
+	ConnectTimeout   time.Duration
+	HandshakeTimeout time.Duration
+	SessionTimeout   time.Duration
+
+	// KeepaliveInterval enables keepalive@openssh.com requests; the
+	// connection is closed after KeepaliveMaxMissed unanswered ones,
+	// which defaults to 3.
+	KeepaliveInterval  time.Duration
+	KeepaliveMaxMissed int
+
+	// Lazy defers connecting until the first method that needs the
+	// connection, so connection errors surface there instead of in Dial.
+	Lazy bool
+	// Reconnect enables re-dialing a dropped connection on next use. A Jump
+	// config without its own policy inherits it, since the target cannot
+	// reconnect through a dead jump host.
+	Reconnect *ReconnectPolicy
+
+	// MaxSessions caps the sessions open at once; further ones wait for
+	// a free slot. Zero selects DefaultMaxSessions and a negative value
+	// removes the cap.
+	MaxSessions int
+
+	// HostKeyAlgorithms overrides the algorithms derived from known_hosts.
+	HostKeyAlgorithms []string
+	KeyExchanges      []string
+	Ciphers           []string
+	MACs              []string
+
+	// Jump is the host to connect through, like ProxyJump. It is dialed
+	// with the same options, except WithPinnedHostKeys, and closed
+	// together with the client.
+	Jump *ClientConfig
+
+	// Logger defaults to printing on stdout.
+	Logger Logger
+	// Dialer opens the underlying connection, for example through a proxy.
+	Dialer func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+func newClient(config ClientConfig) *Client {
+	if target, err := ParseTarget(config.Host); err == nil {
+		config.Host = target.Host
+		if config.User == "" {
+			config.User = target.User
+		}
+		if config.Port == "" {
+			config.Port = target.Port
+		}
+	}
+	if config.Port == "" {
+		config.Port = "22"
+	}
+	return &Client{
+		username:                 config.User,
+		password:                 config.Password,
+		host:                     config.Host,
+		port:                     config.Port,
+		authMethods:              config.Auth,
+		sshKeyPem:                config.PrivateKeyFile,
+		identityFiles:            config.IdentityFiles,
+		sshFolderPath:            config.SSHFolderPath,
+		userKnownHostsFile:       config.KnownHostsFile,
+		extraKnownHostsFiles:     config.ExtraKnownHostsFiles,
+		hostKeyPolicy:            config.HostKeyPolicy,
+		connectTimeout:           config.ConnectTimeout,
+		handshakeTimeout:         config.HandshakeTimeout,
+		sessionTimeout:           config.SessionTimeout,
+		keepaliveInterval:        config.KeepaliveInterval,
+		keepaliveMaxMissed:       config.KeepaliveMaxMissed,
+		lazy:                     config.Lazy,
+		reconnect:                config.Reconnect,
+		maxSessions:              config.MaxSessions,
+		hostKeyAlgorithmOverride: config.HostKeyAlgorithms,
+		keyExchanges:             config.KeyExchanges,
+		ciphers:                  config.Ciphers,
+		macs:                     config.MACs,
+		logger:                   config.Logger,
+		dial:                     config.Dialer,
+
+		stdout: &Writer{},
+	}
+}
+
+// Dial connects using config. Options are applied after config, so they
+// take precedence over it.
+func Dial(ctx context.Context, config ClientConfig, opts ...ClientOption) (*Client, error) {
+	client := newClient(config)
+	client.applyOptions(opts)
+	start := time.Now()
+	client.sshPrint(fmt.Sprintf("Dial start host=%s", client.host))
+	if config.Jump != nil {
+		jumpConfig := *config.Jump
+		if jumpConfig.Reconnect == nil {
+			jumpConfig.Reconnect = config.Reconnect
+		}
+		jump, err := Dial(ctx, jumpConfig, jumpHostOptions(opts)...)
+		if err != nil {
+			client.sshPrint(fmt.Sprintf("Dial error took %s", time.Since(start)))
+			return nil, fmt.Errorf("jump host %s: %w", targetName(*config.Jump), err)
+		}
+		client.dialThrough(jump)
+	}
+	if !client.lazy {
+		if err := client.connect(ctx); err != nil {
+			client.jumpClient.closeConnection()
+			client.sshPrint(fmt.Sprintf("Dial error took %s", time.Since(start)))
+			return nil, err
+		}
+	}
+	client.stackLog = GetStack()
+	addClientToLog(client)
+	client.sshPrint(fmt.Sprintf("Dial done took %s", time.Since(start)))
+	return client, nil
+}
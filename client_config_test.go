@@ -0,0 +1,146 @@
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type testLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) Printf(format string, a ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, a...))
+}
+
+func (l *testLogger) contains(s string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.lines {
+		if strings.Contains(line, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDialUsesPerClientSettings(t *testing.T) {
+	server := newTestSSHServer(t, testSigner(t))
+	host, port := server.hostPort()
+	dir := t.TempDir()
+	// Package state must not leak into Dial.
+	SetSSHFolderPath(t.TempDir())
+	defer SetSSHFolderPath("")
+
+	logger := &testLogger{}
+	dialed := 0
+	client, err := Dial(context.Background(), ClientConfig{
+		User:          "test",
+		Password:      "secret",
+		Host:          host,
+		Port:          port,
+		SSHFolderPath: dir,
+		Logger:        logger,
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed++
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}, WithGlobalKnownHostsFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.closeConnection()
+
+	if dialed != 1 {
+		t.Fatalf("custom dialer used %d times", dialed)
+	}
+	if !logger.contains("ssh Dial done") {
+		t.Fatalf("logger did not receive client output: %v", logger.lines)
+	}
+	entries, err := NewKnownHosts(filepath.Join(dir, "known_hosts")).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected the host key in the configured folder, got %v", entries)
+	}
+}
+
+func TestDialHonoursCancelledContext(t *testing.T) {
+	server := newTestSSHServer(t, testSigner(t))
+	host, port := server.hostPort()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := Dial(ctx, ClientConfig{
+		User:          "test",
+		Password:      "secret",
+		Host:          host,
+		Port:          port,
+		SSHFolderPath: t.TempDir(),
+	}, WithGlobalKnownHostsFiles())
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestDialRequiresPrivateKeyFile(t *testing.T) {
+	server := newTestSSHServer(t, testSigner(t))
+	host, port := server.hostPort()
+	dir := t.TempDir()
+	_, err := Dial(context.Background(), ClientConfig{
+		User:           "test",
+		Host:           host,
+		Port:           port,
+		PrivateKeyFile: filepath.Join(dir, "missing"),
+		SSHFolderPath:  dir,
+	}, WithGlobalKnownHostsFiles())
+	if err == nil {
+		t.Fatal("expected an error for a missing private key file")
+	}
+}
+
+func TestDialReportsMissingSSHFolder(t *testing.T) {
+	server := newTestSSHServer(t, testSigner(t))
+	host, port := server.hostPort()
+	_, err := Dial(context.Background(), ClientConfig{
+		User:          "test",
+		Password:      "secret",
+		Host:          host,
+		Port:          port,
+		SSHFolderPath: filepath.Join(t.TempDir(), "missing"),
+	}, WithGlobalKnownHostsFiles())
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected a missing known_hosts folder error, got %v", err)
+	}
+}
+
+func TestDialPinsOnlyTargetBehindJumpHost(t *testing.T) {
+	bastion := newTestSSHServer(t, testSigner(t))
+	serveDirectTCPIP(bastion)
+	targetKey := testSigner(t)
+	target := newTestSSHServer(t, targetKey)
+	dir := t.TempDir()
+	client, err := Dial(context.Background(), ClientConfig{
+		User:          "test",
+		Password:      "secret",
+		Host:          target.addr,
+		SSHFolderPath: dir,
+		Jump:          &ClientConfig{User: "test", Password: "secret", Host: bastion.addr, SSHFolderPath: dir},
+	}, WithGlobalKnownHostsFiles(), WithPinnedHostKeys(ssh.FingerprintSHA256(targetKey.PublicKey())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.closeConnection()
+}
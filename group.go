@@ -0,0 +1,256 @@
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const DefaultGroupConcurrency = 10
+
+// ErrSkipped is the error of hosts a fail-fast Group did not get to.
+var ErrSkipped = errors.New("ssh: skipped after an earlier failure")
+
+// CommandResult is the outcome of an operation on one host. ExitCode is -1
+// unless a command ran to completion.
+type CommandResult struct {
+	Target string
+	// Command is set by Command; other operations may set it to name
+	// what they did in reports.
+	Command  string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Err      error
+	Started  time.Time
+	Duration time.Duration
+}
+
+func (r CommandResult) OK() bool {
+	return r.Err == nil
+}
+
+func (r CommandResult) Skipped() bool {
+	return errors.Is(r.Err, ErrSkipped)
+}
+
+// Operation is run by a Group on every host. It may fill in result and
+// may use the Client methods that panic; panics are reported as errors.
+type Operation func(ctx context.Context, client *Client, result *CommandResult) error
+
+// Command returns the operation running cmd, capturing stdout and stderr
+// separately. A non-zero exit status is an error.
+func Command(cmd string) Operation {
+	return func(ctx context.Context, client *Client, result *CommandResult) error {
+		result.Command = cmd
+		return client.runCommand(ctx, cmd, result)
+	}
+}
+
+// Group runs one operation on many hosts.
+type Group struct {
+	Targets []ClientConfig
+	// Concurrency defaults to DefaultGroupConcurrency.
+	Concurrency int
+	// HostTimeout bounds connecting and running the operation per host.
+	// When it expires the host's connection is closed, even a pooled one
+	// other callers are holding.
+	HostTimeout time.Duration
+	// FailFast stops starting hosts after the first failure; the hosts
+	// not started are reported with ErrSkipped.
+	FailFast bool
+	// Pool, when set, provides the connections; otherwise every host is
+	// dialed with Options and closed afterwards. The Group itself logs to
+	// the Logger given in Options.
+	Pool    *Pool
+	Options []ClientOption
+}
+
+type GroupSummary struct {
+	Total, Succeeded, Failed, Skipped int
+	Duration                          time.Duration
+}
+
+func (s GroupSummary) String() string {
+	return fmt.Sprintf("%d hosts: %d ok, %d failed, %d skipped in %s", s.Total, s.Succeeded, s.Failed, s.Skipped, s.Duration.Round(time.Millisecond))
+}
+
+type GroupResult struct {
+	// Results are in the order of Group.Targets.
+	Results []CommandResult
+	Summary GroupSummary
+}
+
+func (r GroupResult) Failed() []CommandResult {
+	failed := []CommandResult{}
+	for _, result := range r.Results {
+		if !result.OK() && !result.Skipped() {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+func (g *Group) Run(ctx context.Context, op Operation) GroupResult {
+	start := time.Now()
+	log := optionsLog(g.Options)
+	log.sshPrint(fmt.Sprintf("Group.Run start hosts=%d", len(g.Targets)))
+	concurrency := g.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultGroupConcurrency
+	}
+	// Failing fast stops dispatching new hosts; hosts already running keep
+	// ctx so that their commands are not killed halfway.
+	dispatch, stopDispatch := context.WithCancel(ctx)
+	defer stopDispatch()
+
+	results := make([]CommandResult, len(g.Targets))
+	indexes := make(chan int)
+	var failed sync.Once
+	wg := sync.WaitGroup{}
+	for i := 0; i < min(concurrency, len(g.Targets)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				config := g.Targets[index]
+				if dispatch.Err() != nil {
+					results[index] = CommandResult{Target: targetName(config), ExitCode: -1, Err: ErrSkipped}
+					continue
+				}
+				results[index] = g.runHost(ctx, config, op)
+				if !results[index].OK() && g.FailFast {
+					failed.Do(stopDispatch)
+				}
+			}
+		}()
+	}
+	for index := range g.Targets {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+
+	result := GroupResult{Results: results, Summary: summarize(results)}
+	result.Summary.Duration = time.Since(start)
+	log.sshPrint(fmt.Sprintf("Group.Run done %s", result.Summary))
+	return result
+}
+
+func summarize(results []CommandResult) GroupSummary {
+	summary := GroupSummary{Total: len(results)}
+	for _, result := range results {
+		switch {
+		case result.OK():
+			summary.Succeeded++
+		case result.Skipped():
+			summary.Skipped++
+		default:
+			summary.Failed++
+		}
+	}
+	return summary
+}
+
+func (g *Group) runHost(ctx context.Context, config ClientConfig, op Operation) (result CommandResult) {
+	result = CommandResult{Target: targetName(config), ExitCode: -1, Started: time.Now()}
+	defer func() {
+		result.Duration = time.Since(result.Started)
+	}()
+	if g.HostTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.HostTimeout)
+		defer cancel()
+	}
+
+	// Client methods run with their own timeouts rather than ctx, so when
+	// the host runs out of time its connection is closed under the
+	// operation; a pooled connection is dropped from the pool first.
+	var client *Client
+	var abandon func()
+	if g.Pool != nil {
+		pooled, err := g.Pool.Get(ctx, config)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		defer pooled.Release()
+		client = pooled.Client
+		abandon = func() {
+			g.Pool.abandon(pooled.entry)
+		}
+	} else {
+		var err error
+		client, err = Dial(ctx, config, g.Options...)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		defer client.closeConnection()
+		abandon = client.closeConnection
+	}
+	stop := context.AfterFunc(ctx, abandon)
+	defer stop()
+	result.Err = runOperation(ctx, client, op, &result)
+	if result.Err != nil && ctx.Err() != nil && !errors.Is(result.Err, ctx.Err()) {
+		result.Err = fmt.Errorf("%w: %v", ctx.Err(), result.Err)
+	}
+	return result
+}
+
+func runOperation(ctx context.Context, client *Client, op Operation, result *CommandResult) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return op(ctx, client, result)
+}
+
+func targetName(config ClientConfig) string {
+	client := newClient(config)
+	return Target{User: client.username, Host: client.host, Port: client.port}.String()
+}
+
+// runCommand runs cmd without a pty so that stdout and stderr stay apart.
+// Cancelling ctx kills the command.
+func (c *Client) runCommand(ctx context.Context, cmd string, result *CommandResult) error {
+	session, err := c.newSession(ctx)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	if err := session.Start(cmd); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		<-done
+		err = ctx.Err()
+	}
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		result.ExitCode = 0
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitStatus()
+	}
+	return err
+}
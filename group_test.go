@@ -0,0 +1,195 @@
+package sshclient
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// serveExec answers exec requests with a tiny shell: "echo ARGS" prints
+// ARGS, "fail" writes to stderr and exits 3, "hang" never returns and
+// anything else exits 127.
+func serveExec(server *testSSHServer) {
+	server.handleConn = func(conn *ssh.ServerConn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request) {
+		go ssh.DiscardRequests(reqs)
+		for newChannel := range chans {
+			if newChannel.ChannelType() != "session" {
+				newChannel.Reject(ssh.UnknownChannelType, "only sessions")
+				continue
+			}
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go func() {
+				defer channel.Close()
+				for req := range requests {
+					if req.Type != "exec" {
+						req.Reply(req.Type == "pty-req", nil)
+						continue
+					}
+					req.Reply(true, nil)
+					cmd := string(req.Payload[4:])
+					status := uint32(0)
+					switch {
+					case strings.HasPrefix(cmd, "echo "):
+						channel.Write([]byte(strings.TrimPrefix(cmd, "echo ") + "\n"))
+					case cmd == "fail":
+						channel.Stderr().Write([]byte("boom\n"))
+						status = 3
+					case cmd == "hang":
+						for range requests {
+						}
+						return
+					default:
+						status = 127
+					}
+					payload := binary.BigEndian.AppendUint32(nil, status)
+					channel.SendRequest("exit-status", false, payload)
+					return
+				}
+			}()
+		}
+	}
+}
+
+func newExecTargets(t *testing.T, n int) []ClientConfig {
+	t.Helper()
+	dir := t.TempDir()
+	targets := []ClientConfig{}
+	for i := 0; i < n; i++ {
+		server := newTestSSHServer(t, testSigner(t))
+		serveExec(server)
+		targets = append(targets, ClientConfig{
+			User:          "test",
+			Password:      "secret",
+			Host:          server.addr,
+			SSHFolderPath: dir,
+		})
+	}
+	return targets
+}
+
+func TestGroupRunsCommandOnEveryHost(t *testing.T) {
+	targets := newExecTargets(t, 5)
+	group := &Group{Targets: targets, Concurrency: 2, Options: []ClientOption{WithGlobalKnownHostsFiles()}}
+	result := group.Run(context.Background(), Command("echo hello"))
+
+	if result.Summary.Succeeded != 5 || result.Summary.Failed != 0 {
+		t.Fatalf("unexpected summary %s", result.Summary)
+	}
+	for i, r := range result.Results {
+		if r.Stdout != "hello\n" || r.ExitCode != 0 {
+			t.Fatalf("unexpected result %+v", r)
+		}
+		if r.Target != "test@"+targets[i].Host {
+			t.Fatalf("results out of order: %s for %s", r.Target, targets[i].Host)
+		}
+	}
+}
+
+func TestGroupLogsToOptionsLogger(t *testing.T) {
+	logger := &testLogger{}
+	group := &Group{Targets: newExecTargets(t, 1), Options: []ClientOption{WithGlobalKnownHostsFiles(), WithLogger(logger)}}
+	group.Run(context.Background(), Command("echo hello"))
+	for _, want := range []string{"ssh Group.Run start", "ssh Group.Run done", "ssh hostKeyAlgorithmsFromCallback start"} {
+		if !logger.contains(want) {
+			t.Fatalf("logger is missing %q: %v", want, logger.lines)
+		}
+	}
+}
+
+func TestGroupReportsExitStatusAndStderr(t *testing.T) {
+	targets := newExecTargets(t, 1)
+	group := &Group{Targets: targets, Options: []ClientOption{WithGlobalKnownHostsFiles()}}
+	result := group.Run(context.Background(), Command("fail"))
+	r := result.Results[0]
+	var exitErr *ssh.ExitError
+	if !errors.As(r.Err, &exitErr) || r.ExitCode != 3 || r.Stderr != "boom\n" {
+		t.Fatalf("unexpected result %+v", r)
+	}
+	if len(result.Failed()) != 1 {
+		t.Fatalf("Failed() = %v", result.Failed())
+	}
+}
+
+func TestGroupFailFastSkipsRemainingHosts(t *testing.T) {
+	targets := newExecTargets(t, 4)
+	var ran int32
+	group := &Group{Targets: targets, Concurrency: 1, FailFast: true, Options: []ClientOption{WithGlobalKnownHostsFiles()}}
+	result := group.Run(context.Background(), func(ctx context.Context, client *Client, result *CommandResult) error {
+		if atomic.AddInt32(&ran, 1) == 2 {
+			panic("deploy script failed")
+		}
+		return nil
+	})
+	s := result.Summary
+	if s.Succeeded != 1 || s.Failed != 1 || s.Skipped != 2 {
+		t.Fatalf("unexpected summary %s", s)
+	}
+	if !strings.Contains(result.Results[1].Err.Error(), "deploy script failed") {
+		t.Fatalf("panic was not reported: %v", result.Results[1].Err)
+	}
+}
+
+func TestGroupFailFastLetsRunningHostsFinish(t *testing.T) {
+	targets := newExecTargets(t, 3)
+	failing := make(chan struct{})
+	group := &Group{Targets: targets, Concurrency: 2, FailFast: true, Options: []ClientOption{WithGlobalKnownHostsFiles()}}
+	result := group.Run(context.Background(), func(ctx context.Context, client *Client, result *CommandResult) error {
+		if result.Target == "test@"+targets[0].Host {
+			close(failing)
+			return errors.New("deploy script failed")
+		}
+		<-failing
+		// The second host is still running when the first one fails.
+		time.Sleep(100 * time.Millisecond)
+		return client.runCommand(ctx, "echo done", result)
+	})
+	if err := result.Results[1].Err; err != nil || result.Results[1].Stdout != "done\n" {
+		t.Fatalf("running host was interrupted: %+v", result.Results[1])
+	}
+	if !result.Results[2].Skipped() {
+		t.Fatalf("expected the third host to be skipped, got %+v", result.Results[2])
+	}
+}
+
+func TestGroupHostTimeoutKillsCommand(t *testing.T) {
+	targets := newExecTargets(t, 1)
+	group := &Group{Targets: targets, HostTimeout: 200 * time.Millisecond, Options: []ClientOption{WithGlobalKnownHostsFiles()}}
+	result := group.Run(context.Background(), Command("hang"))
+	if !errors.Is(result.Results[0].Err, context.DeadlineExceeded) {
+		t.Fatalf("expected a deadline error, got %v", result.Results[0].Err)
+	}
+}
+
+func TestGroupHostTimeoutStopsClientMethods(t *testing.T) {
+	for _, pooled := range []bool{false, true} {
+		targets := newExecTargets(t, 1)
+		group := &Group{Targets: targets, HostTimeout: 200 * time.Millisecond, Options: []ClientOption{WithGlobalKnownHostsFiles()}}
+		if pooled {
+			group.Pool = NewPool(PoolConfig{}, WithGlobalKnownHostsFiles())
+			defer group.Pool.Close()
+		}
+		start := time.Now()
+		result := group.Run(context.Background(), func(ctx context.Context, client *Client, result *CommandResult) error {
+			client.Output("hang")
+			return nil
+		})
+		if !errors.Is(result.Results[0].Err, context.DeadlineExceeded) {
+			t.Fatalf("pooled=%v: expected a deadline error, got %v", pooled, result.Results[0].Err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Fatalf("pooled=%v: operation ran for %s", pooled, elapsed)
+		}
+		if pooled && group.Pool.Len() != 0 {
+			t.Fatalf("expected the timed out connection to leave the pool, got %d", group.Pool.Len())
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package sshclient
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+type HostKeyDecision int
+
+const (
+	HostKeyReject HostKeyDecision = iota
+	HostKeyAcceptOnce
+	HostKeyAcceptAndPersist
+)
+
+func (d HostKeyDecision) String() string {
+	switch d {
+	case HostKeyReject:
+		return "reject"
+	case HostKeyAcceptOnce:
+		return "accept-once"
+	case HostKeyAcceptAndPersist:
+		return "accept-and-persist"
+	}
+	return fmt.Sprintf("HostKeyDecision(%d)", int(d))
+}
+
+// HostKeyPrompt describes a host key that is not trusted yet. KnownKeys is
+// empty for a host seen for the first time and holds the previously
+// trusted keys when the host key changed.
+type HostKeyPrompt struct {
+	Host        string
+	Remote      net.Addr
+	Key         ssh.PublicKey
+	Fingerprint string
+	KnownKeys   []ssh.PublicKey
+}
+
+func (p HostKeyPrompt) Changed() bool {
+	return len(p.KnownKeys) > 0
+}
+
+type HostKeyApprover func(prompt HostKeyPrompt) (HostKeyDecision, error)
+
+func (c *Client) approveHostKey(host string, remote net.Addr, pubKey ssh.PublicKey, keyErr *knownhosts.KeyError) error {
+	prompt := HostKeyPrompt{
+		Host:        host,
+		Remote:      remote,
+		Key:         pubKey,
+		Fingerprint: ssh.FingerprintSHA256(pubKey),
+	}
+	for _, known := range keyErr.Want {
+		prompt.KnownKeys = append(prompt.KnownKeys, known.Key)
+	}
+	decision, err := c.hostKeyApprover(prompt)
+	if err != nil {
+		return err
+	}
+	c.log("Host key %s of %s: %s.", prompt.Fingerprint, host, decision)
+
+	switch decision {
+	case HostKeyAcceptOnce:
+		return nil
+	case HostKeyAcceptAndPersist:
+		if prompt.Changed() {
+			if _, err := c.getHostKeyStore().Remove(host); err != nil {
+				return err
+			}
+		}
+		return c.addHostKey(host, remote, pubKey)
+	}
+	return keyErr
+}
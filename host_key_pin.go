@@ -0,0 +1,66 @@
+package sshclient
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type HostKeyMismatchError struct {
+	Host     string
+	Expected []string
+	Actual   string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	if len(e.Expected) == 0 {
+		return fmt.Sprintf("host key %s of %s is not pinned and no fingerprints are pinned for this host", e.Actual, e.Host)
+	}
+	return fmt.Sprintf("host key %s of %s does not match expected fingerprints %s", e.Actual, e.Host, strings.Join(e.Expected, ", "))
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	fingerprint = strings.TrimSpace(fingerprint)
+	if !strings.HasPrefix(fingerprint, "SHA256:") {
+		fingerprint = "SHA256:" + fingerprint
+	}
+	return strings.TrimRight(fingerprint, "=")
+}
+
+func (c *Client) pinsForHost(host string) []string {
+	pins := []string{}
+	for _, fingerprint := range c.pinnedFingerprints {
+		pins = append(pins, normalizeFingerprint(fingerprint))
+	}
+	for pinnedHost, fingerprints := range c.pinnedHostKeys {
+		if normalizeHost(pinnedHost) != normalizeHost(host) {
+			continue
+		}
+		for _, fingerprint := range fingerprints {
+			pins = append(pins, normalizeFingerprint(fingerprint))
+		}
+	}
+	return pins
+}
+
+// checkPinnedHostKey verifies pubKey against the fingerprints pinned for
+// host. A certificate matches when its certified key is pinned.
+func (c *Client) checkPinnedHostKey(host string, pubKey ssh.PublicKey, pins []string) error {
+	candidates := []ssh.PublicKey{pubKey}
+	if cert, ok := pubKey.(*ssh.Certificate); ok {
+		candidates = append(candidates, cert.Key)
+	}
+	for _, candidate := range candidates {
+		fingerprint := ssh.FingerprintSHA256(candidate)
+		for _, pinned := range pins {
+			if pinned == fingerprint {
+				c.log("Pinned pub key %s matches for %s.", fingerprint, host)
+				return nil
+			}
+		}
+	}
+	err := &HostKeyMismatchError{Host: host, Expected: pins, Actual: ssh.FingerprintSHA256(pubKey)}
+	c.log("WARNING: %v", err)
+	return err
+}
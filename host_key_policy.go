@@ -0,0 +1,45 @@
+package sshclient
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type HostKeyPolicy int
+
+const (
+	// HostKeyPolicyAcceptNew trusts unknown hosts on first use and records
+	// their key in known_hosts. Changed keys are still rejected.
+	HostKeyPolicyAcceptNew HostKeyPolicy = iota
+	// HostKeyPolicyStrict only accepts keys already present in known_hosts.
+	HostKeyPolicyStrict
+	// HostKeyPolicyPinned only accepts keys matching the pinned fingerprints
+	// and never reads or writes known_hosts.
+	HostKeyPolicyPinned
+	// HostKeyPolicyInsecure accepts any host key.
+	HostKeyPolicyInsecure
+)
+
+func (p HostKeyPolicy) String() string {
+	switch p {
+	case HostKeyPolicyAcceptNew:
+		return "accept-new"
+	case HostKeyPolicyStrict:
+		return "strict"
+	case HostKeyPolicyPinned:
+		return "pinned"
+	case HostKeyPolicyInsecure:
+		return "insecure"
+	}
+	return fmt.Sprintf("HostKeyPolicy(%d)", int(p))
+}
+
+func (c *Client) usesKnownHosts() bool {
+	return c.hostKeyPolicy == HostKeyPolicyAcceptNew || c.hostKeyPolicy == HostKeyPolicyStrict
+}
+
+func (c *Client) checkInsecureHostKey(host string, pubKey ssh.PublicKey) error {
+	c.log("WARNING: host key checking is DISABLED for %s, accepting %s %s without verification. This connection is open to MiTM attacks.", host, pubKey.Type(), ssh.FingerprintSHA256(pubKey))
+	return nil
+}
@@ -0,0 +1,164 @@
+package sshclient
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// HostKeyStore holds the trusted host keys used to verify servers.
+// Lookup returns every entry that applies to host, given as "host" or
+// "host:port": plain keys, @cert-authority entries and all @revoked
+// entries, which apply to every host.
+type HostKeyStore interface {
+	Lookup(host string) ([]KnownHostEntry, error)
+	Add(hosts []string, key ssh.PublicKey) error
+	Remove(host string) (int, error)
+	List() ([]KnownHostEntry, error)
+}
+
+var (
+	_ HostKeyStore = (*KnownHosts)(nil)
+	_ HostKeyStore = (*MemoryHostKeyStore)(nil)
+)
+
+type MemoryHostKeyStore struct {
+	mu      sync.RWMutex
+	entries []KnownHostEntry
+}
+
+func NewMemoryHostKeyStore(entries ...KnownHostEntry) *MemoryHostKeyStore {
+	return &MemoryHostKeyStore{entries: append([]KnownHostEntry{}, entries...)}
+}
+
+func (s *MemoryHostKeyStore) Lookup(host string) ([]KnownHostEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := []KnownHostEntry{}
+	for i, entry := range s.entries {
+		line := knownHostLineFromEntry(entry)
+		if line.marker == knownHostsMarkerRevoked || line.matchesHost(host) {
+			entry.Line = i + 1
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (s *MemoryHostKeyStore) Add(hosts []string, key ssh.PublicKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addrs := []string{}
+	for _, host := range hosts {
+		addrs = append(addrs, normalizeHost(host))
+	}
+	for _, entry := range s.entries {
+		if entry.Marker == knownHostsMarkerRevoked && bytes.Equal(entry.Key.Marshal(), key.Marshal()) {
+			return &HostKeyRevokedError{Host: strings.Join(addrs, ","), Key: key, Source: "memory"}
+		}
+	}
+	for _, entry := range s.entries {
+		if entry.Marker != "" || !bytes.Equal(entry.Key.Marshal(), key.Marshal()) {
+			continue
+		}
+		for _, addr := range addrs {
+			if knownHostLineFromEntry(entry).matchesHost(addr) {
+				return nil
+			}
+		}
+	}
+	s.entries = append(s.entries, KnownHostEntry{Hosts: addrs, Key: key})
+	return nil
+}
+
+func (s *MemoryHostKeyStore) Remove(host string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := []KnownHostEntry{}
+	for _, entry := range s.entries {
+		if entry.Marker == "" && knownHostLineFromEntry(entry).matchesHost(host) {
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	removed := len(s.entries) - len(kept)
+	s.entries = kept
+	return removed, nil
+}
+
+func (s *MemoryHostKeyStore) List() ([]KnownHostEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := make([]KnownHostEntry, len(s.entries))
+	for i, entry := range s.entries {
+		entry.Line = i + 1
+		entries[i] = entry
+	}
+	return entries, nil
+}
+
+func knownHostLineFromEntry(entry KnownHostEntry) knownHostLine {
+	patterns := strings.Join(entry.Hosts, ",")
+	line := knownHostLine{
+		marker:   entry.Marker,
+		patterns: patterns,
+		key:      entry.Key,
+		lineNum:  entry.Line,
+	}
+	if !strings.HasPrefix(patterns, "|") {
+		line.hostPatterns = parseKnownHostPatterns(patterns)
+	}
+	return line
+}
+
+func snapshotFromEntries(source string, entries []KnownHostEntry) *knownHostsSnapshot {
+	snapshot := &knownHostsSnapshot{path: source}
+	for _, entry := range entries {
+		snapshot.lines = append(snapshot.lines, knownHostLineFromEntry(entry))
+	}
+	return snapshot
+}
+
+func hostKeyStoreName(store HostKeyStore) string {
+	if kh, ok := store.(*KnownHosts); ok {
+		return kh.Path()
+	}
+	return fmt.Sprintf("%T", store)
+}
+
+func (c *Client) getHostKeyStore() HostKeyStore {
+	if c.hostKeyStore != nil {
+		return c.hostKeyStore
+	}
+	return c.KnownHosts()
+}
+
+// hostKeySnapshot gathers the entries for host from the read-only global
+// known_hosts files, in order, followed by the client's own store.
+func (c *Client) hostKeySnapshot(host string) (*knownHostsSnapshot, error) {
+	start := time.Now()
+	c.sshPrint("hostKeySnapshot start")
+	defer func() {
+		c.sshPrint(fmt.Sprintf("hostKeySnapshot done took %s", time.Since(start)))
+	}()
+	store := c.getHostKeyStore()
+	snapshot := &knownHostsSnapshot{path: hostKeyStoreName(store)}
+	for _, path := range slices.Concat(c.getGlobalKnownHostsFiles(), c.extraKnownHostsFiles) {
+		global, err := loadKnownHostsSnapshot(path)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.appendMatching(global, host)
+	}
+	entries, err := store.Lookup(host)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.appendMatching(snapshotFromEntries(snapshot.path, entries), host)
+	return snapshot, nil
+}
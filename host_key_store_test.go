@@ -0,0 +1,112 @@
+package sshclient
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestMemoryHostKeyStoreAddLookupRemove(t *testing.T) {
+	store := NewMemoryHostKeyStore()
+	key := testEd25519Key(t)
+	if err := store.Add([]string{"web.example:22", "10.0.0.1"}, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add([]string{"10.0.0.1:22"}, key); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Hosts[0] != "web.example" {
+		t.Fatalf("got %v", entries)
+	}
+	if found, _ := store.Lookup("10.0.0.1:22"); len(found) != 1 {
+		t.Fatalf("got %v", found)
+	}
+	if found, _ := store.Lookup("db.example"); len(found) != 0 {
+		t.Fatalf("got %v", found)
+	}
+	removed, err := store.Remove("web.example")
+	if err != nil || removed != 1 {
+		t.Fatalf("removed %d, err %v", removed, err)
+	}
+}
+
+func TestHostKeyCallbackUsesCustomStore(t *testing.T) {
+	c := newTestClient(t)
+	store := NewMemoryHostKeyStore()
+	c.hostKeyStore = store
+	key := testECDSAKey(t)
+	if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), key); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), key); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := store.List(); len(entries) != 1 {
+		t.Fatalf("got %v", entries)
+	}
+	err := c.hostKeyCallback("127.0.0.1:22", testRemote(), testECDSAKey(t))
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) || len(keyErr.Want) != 1 {
+		t.Fatalf("expected mismatch, got %v", err)
+	}
+	if algos := c.hostKeyAlgorithms("127.0.0.1:22"); len(algos) != 1 {
+		t.Fatalf("got %v", algos)
+	}
+	if _, err := os.Stat(c.knownHostsPath()); !os.IsNotExist(err) {
+		t.Fatal("custom store should not touch known_hosts")
+	}
+}
+
+func TestHostKeyCallbackRefusesKeyRevokedInStore(t *testing.T) {
+	c := newTestClient(t)
+	key := testEd25519Key(t)
+	c.hostKeyStore = NewMemoryHostKeyStore(KnownHostEntry{Marker: "@revoked", Hosts: []string{"*"}, Key: key})
+	err := c.hostKeyCallback("127.0.0.1:22", testRemote(), key)
+	var revokedErr *HostKeyRevokedError
+	if !errors.As(err, &revokedErr) {
+		t.Fatalf("expected revoked error, got %v", err)
+	}
+	if err := c.hostKeyStore.Add([]string{"127.0.0.1"}, key); !errors.As(err, &revokedErr) {
+		t.Fatalf("expected revoked error from Add, got %v", err)
+	}
+}
+
+func TestKnownHostsAddRefusesRevokedKey(t *testing.T) {
+	key := testEd25519Key(t)
+	kh := newTestKnownHosts(t, "@revoked * "+serializedHostKey(key)+"\n")
+	err := kh.Add([]string{"web.example"}, key)
+	var revokedErr *HostKeyRevokedError
+	if !errors.As(err, &revokedErr) {
+		t.Fatalf("expected revoked error, got %v", err)
+	}
+}
+
+func TestKnownHostsReplaceRefusesRevokedKey(t *testing.T) {
+	oldKey := testECDSAKey(t)
+	key := testEd25519Key(t)
+	kh := newTestKnownHosts(t, knownhosts.Line([]string{"web.example"}, oldKey)+"\n@revoked * "+serializedHostKey(key)+"\n")
+	err := kh.Replace("web.example", key)
+	var revokedErr *HostKeyRevokedError
+	if !errors.As(err, &revokedErr) {
+		t.Fatalf("expected revoked error, got %v", err)
+	}
+	found, err := kh.Lookup("web.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range found {
+		if entry.Marker == "" && entry.Fingerprint() != ssh.FingerprintSHA256(oldKey) {
+			t.Fatalf("revoked key was written: %v", found)
+		}
+	}
+	if len(found) != 2 {
+		t.Fatalf("the old key must be kept, got %v", found)
+	}
+}
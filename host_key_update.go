@@ -0,0 +1,255 @@
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	hostKeysRequest      = "hostkeys-00@openssh.com"
+	hostKeysProveRequest = "hostkeys-prove-00@openssh.com"
+)
+
+func (c *Client) dialSSH(ctx context.Context, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	var serverKey ssh.PublicKey
+	var timer *phaseTimer
+	learnHostKeys := c.updatesHostKeys()
+	cfg := *config
+	cfg.HostKeyCallback = func(host string, remote net.Addr, key ssh.PublicKey) error {
+		// An approver may prompt an operator, which must not count against
+		// the handshake timeout.
+		if c.hostKeyApprover != nil {
+			timer.pause()
+			defer timer.resume()
+		}
+		err := config.HostKeyCallback(host, remote, key)
+		if err == nil {
+			serverKey = key
+			// A key the approver accepted once is not in the store; that
+			// connection must not teach the store any keys.
+			if learnHostKeys && c.hostKeyApprover != nil {
+				learnHostKeys = c.hostKeyStored(host, remote, key)
+			}
+		}
+		return err
+	}
+
+	conn, err := c.dialTimeout(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	// Cancelling ctx aborts the handshake; the connection outlives it.
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	var sshConn ssh.Conn
+	var chans <-chan ssh.NewChannel
+	var reqs <-chan *ssh.Request
+	timeout := timeoutOrDefault(c.handshakeTimeout, DefaultHandshakeTimeout)
+	err = runWithPausableTimeout(TimeoutPhaseHandshake, addr, timeout, func() {
+		conn.Close()
+	}, func(handshakeTimer *phaseTimer) error {
+		var err error
+		timer = handshakeTimer
+		sshConn, chans, reqs, err = ssh.NewClientConn(conn, addr, &cfg)
+		return err
+	})
+	if !stop() {
+		err = ctx.Err()
+	}
+	if err != nil {
+		// The handshake may still complete after a timeout; closing conn
+		// makes it fail and nothing else holds a reference to it.
+		conn.Close()
+		return nil, err
+	}
+	if learnHostKeys {
+		reqs = c.watchHostKeyUpdates(sshConn, addr, serverKey, reqs)
+	}
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+func (c *Client) updatesHostKeys() bool {
+	return !c.disableHostKeyUpdates && c.usesKnownHosts()
+}
+
+func (c *Client) hostKeyStored(host string, remote net.Addr, key ssh.PublicKey) bool {
+	snapshot, err := c.hostKeySnapshot(host)
+	return err == nil && snapshot.check(host, remote, key) == nil
+}
+
+// watchHostKeyUpdates takes hostkeys-00@openssh.com announcements out of
+// the global request stream and hands every other request on unchanged.
+func (c *Client) watchHostKeyUpdates(conn ssh.Conn, host string, serverKey ssh.PublicKey, reqs <-chan *ssh.Request) <-chan *ssh.Request {
+	forwarded := make(chan *ssh.Request)
+	go func() {
+		defer close(forwarded)
+		for req := range reqs {
+			if req.Type != hostKeysRequest {
+				forwarded <- req
+				continue
+			}
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+			// Proving keys needs a round trip on this connection, which
+			// must not block the request loop.
+			go func(payload []byte) {
+				if err := c.updateHostKeys(conn, host, serverKey, payload); err != nil {
+					c.log("WARNING: ignoring host key update from %s: %v", host, err)
+				}
+			}(req.Payload)
+		}
+	}()
+	return forwarded
+}
+
+func (c *Client) updateHostKeys(conn ssh.Conn, host string, serverKey ssh.PublicKey, payload []byte) error {
+	start := time.Now()
+	c.sshPrint(fmt.Sprintf("updateHostKeys start host=%s", host))
+	defer func() {
+		c.sshPrint(fmt.Sprintf("updateHostKeys done took %s", time.Since(start)))
+	}()
+	if serverKey == nil {
+		return errors.New("connection host key is unknown")
+	}
+	if _, ok := serverKey.(*ssh.Certificate); ok {
+		// Hosts trusted through a certificate authority have nothing to
+		// learn, the same as OpenSSH.
+		return nil
+	}
+	announced, err := parseHostKeyBlobs(payload)
+	if err != nil {
+		return err
+	}
+	if !containsHostKey(announced, serverKey) {
+		return errors.New("server did not announce the key used for this connection")
+	}
+
+	snapshot, err := c.hostKeySnapshot(host)
+	if err != nil {
+		return err
+	}
+	newKeys := []ssh.PublicKey{}
+	for _, key := range announced {
+		if bytes.Equal(key.Marshal(), serverKey.Marshal()) || snapshot.check(host, conn.RemoteAddr(), key) == nil {
+			continue
+		}
+		if c.checkRevokedHostKey(host, key, snapshot.lines) != nil {
+			continue
+		}
+		newKeys = append(newKeys, key)
+	}
+	if len(newKeys) == 0 {
+		return nil
+	}
+
+	proved, err := proveHostKeys(conn, newKeys)
+	if err != nil {
+		return err
+	}
+	for _, key := range proved {
+		c.log("Learned new host key %s %s for %s from the server.", key.Type(), ssh.FingerprintSHA256(key), host)
+		if err := c.addHostKey(host, conn.RemoteAddr(), key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// proveHostKeys asks the server to sign the session identifier with every
+// key, returning only the keys whose signature verifies.
+func proveHostKeys(conn ssh.Conn, keys []ssh.PublicKey) ([]ssh.PublicKey, error) {
+	request := []byte{}
+	for _, key := range keys {
+		request = appendSSHString(request, key.Marshal())
+	}
+	ok, response, err := conn.SendRequest(hostKeysProveRequest, true, request)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("server refused to prove host keys")
+	}
+	signatures, err := parseSSHStrings(response)
+	if err != nil {
+		return nil, err
+	}
+	if len(signatures) != len(keys) {
+		return nil, fmt.Errorf("server proved %d of %d host keys", len(signatures), len(keys))
+	}
+
+	proved := []ssh.PublicKey{}
+	for i, key := range keys {
+		sig := new(ssh.Signature)
+		if err := ssh.Unmarshal(signatures[i], sig); err != nil {
+			return nil, err
+		}
+		if err := key.Verify(hostKeyProofData(conn.SessionID(), key), sig); err != nil {
+			return nil, fmt.Errorf("bad proof for host key %s: %v", ssh.FingerprintSHA256(key), err)
+		}
+		proved = append(proved, key)
+	}
+	return proved, nil
+}
+
+func hostKeyProofData(sessionID []byte, key ssh.PublicKey) []byte {
+	data := appendSSHString(nil, []byte(hostKeysProveRequest))
+	data = appendSSHString(data, sessionID)
+	return appendSSHString(data, key.Marshal())
+}
+
+func parseHostKeyBlobs(payload []byte) ([]ssh.PublicKey, error) {
+	blobs, err := parseSSHStrings(payload)
+	if err != nil {
+		return nil, err
+	}
+	keys := []ssh.PublicKey{}
+	for _, blob := range blobs {
+		key, err := ssh.ParsePublicKey(blob)
+		if err != nil {
+			// Unknown key types are skipped, as OpenSSH does.
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func containsHostKey(keys []ssh.PublicKey, key ssh.PublicKey) bool {
+	for _, candidate := range keys {
+		if bytes.Equal(candidate.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+func appendSSHString(buf, s []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(s)))
+	return append(buf, s...)
+}
+
+func parseSSHStrings(payload []byte) ([][]byte, error) {
+	strs := [][]byte{}
+	for len(payload) > 0 {
+		if len(payload) < 4 {
+			return nil, errors.New("truncated string length")
+		}
+		n := binary.BigEndian.Uint32(payload)
+		payload = payload[4:]
+		if uint32(len(payload)) < n {
+			return nil, errors.New("truncated string")
+		}
+		strs = append(strs, payload[:n])
+		payload = payload[n:]
+	}
+	return strs, nil
+}
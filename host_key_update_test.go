@@ -0,0 +1,128 @@
+package sshclient
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func serveHostKeyRotation(server *testSSHServer, announced []ssh.Signer, forgeProof bool) {
+	server.handleConn = func(conn *ssh.ServerConn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request) {
+		go func() {
+			for req := range reqs {
+				if req.Type != hostKeysProveRequest {
+					if req.WantReply {
+						req.Reply(false, nil)
+					}
+					continue
+				}
+				blobs, _ := parseSSHStrings(req.Payload)
+				response := []byte{}
+				for _, blob := range blobs {
+					for _, signer := range announced {
+						if string(signer.PublicKey().Marshal()) != string(blob) {
+							continue
+						}
+						sessionID := conn.SessionID()
+						if forgeProof {
+							sessionID = []byte("forged")
+						}
+						sig, _ := signer.Sign(rand.Reader, hostKeyProofData(sessionID, signer.PublicKey()))
+						response = appendSSHString(response, ssh.Marshal(sig))
+					}
+				}
+				req.Reply(true, response)
+			}
+		}()
+		payload := []byte{}
+		for _, signer := range announced {
+			payload = appendSSHString(payload, signer.PublicKey().Marshal())
+		}
+		conn.SendRequest(hostKeysRequest, false, payload)
+		for newChannel := range chans {
+			newChannel.Reject(ssh.Prohibited, "not supported by test server")
+		}
+	}
+}
+
+func waitForKnownHostsEntries(t *testing.T, path string, want int) []KnownHostEntry {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		entries, err := NewKnownHosts(path).List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) >= want || time.Now().After(deadline) {
+			return entries
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClientLearnsProvedHostKeys(t *testing.T) {
+	current := testSigner(t)
+	rotated := testECDSASigner(t)
+	server := newTestSSHServer(t, current)
+	serveHostKeyRotation(server, []ssh.Signer{current, rotated}, false)
+
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	host, port := server.hostPort()
+	client, err := NewClientPasswordAuth("test", "secret", host, port, WithKnownHostsFile(path), WithGlobalKnownHostsFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.client.Close()
+
+	entries := waitForKnownHostsEntries(t, path, 2)
+	if len(entries) != 2 || entries[1].Fingerprint() != ssh.FingerprintSHA256(rotated.PublicKey()) {
+		t.Fatalf("expected rotated key to be learned, got %v", entries)
+	}
+}
+
+func TestClientDoesNotLearnKeysOnceApprovedConnection(t *testing.T) {
+	current := testSigner(t)
+	rotated := testECDSASigner(t)
+	server := newTestSSHServer(t, current)
+	serveHostKeyRotation(server, []ssh.Signer{current, rotated}, false)
+
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	host, port := server.hostPort()
+	client, err := NewClientPasswordAuth("test", "secret", host, port, WithKnownHostsFile(path), WithGlobalKnownHostsFiles(),
+		WithHostKeyApprover(func(prompt HostKeyPrompt) (HostKeyDecision, error) {
+			return HostKeyAcceptOnce, nil
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.client.Close()
+
+	time.Sleep(200 * time.Millisecond)
+	if content, err := os.ReadFile(path); err != nil || len(content) != 0 {
+		t.Fatalf("known_hosts changed after accepting once: %q, %v", content, err)
+	}
+}
+
+func TestClientIgnoresUnprovedHostKeys(t *testing.T) {
+	current := testSigner(t)
+	rotated := testECDSASigner(t)
+	server := newTestSSHServer(t, current)
+	serveHostKeyRotation(server, []ssh.Signer{current, rotated}, true)
+
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	host, port := server.hostPort()
+	client, err := NewClientPasswordAuth("test", "secret", host, port, WithKnownHostsFile(path), WithGlobalKnownHostsFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.client.Close()
+
+	time.Sleep(200 * time.Millisecond)
+	if entries := waitForKnownHostsEntries(t, path, 1); len(entries) != 1 {
+		t.Fatalf("forged proof should not add keys, got %v", entries)
+	}
+}
@@ -0,0 +1,422 @@
+package sshclient
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const maxInventoryJumpDepth = 8
+
+// Inventory is a list of hosts in groups, read from an INI-like file:
+//
+//	bastion host=203.0.113.10
+//
+//	[web]
+//	web1 host=10.0.0.1
+//	web2 host=10.0.0.2 port=2222
+//
+//	[web:vars]
+//	user=deploy
+//	key=~/.ssh/deploy
+//	jump=bastion
+//	sudo_password=env:WEB_SUDO_PASSWORD
+//
+//	[prod:children]
+//	web
+//
+// Hosts listed before the first section belong to no group but "all".
+// Variables of a host override those of its groups, children override
+// parents, and [all:vars] applies to every host.
+type Inventory struct {
+	hosts     []*InventoryHost
+	hostIndex map[string]*InventoryHost
+	groups    map[string]*inventoryGroup
+}
+
+type InventoryHost struct {
+	Name string
+	// Vars are the host's variables merged with those of its groups.
+	Vars map[string]string
+
+	vars   map[string]string
+	groups []string
+}
+
+type inventoryGroup struct {
+	name     string
+	hosts    []string
+	children []string
+	vars     map[string]string
+}
+
+func LoadInventory(path string) (*Inventory, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	inv, err := ParseInventory(f)
+	if err != nil {
+		return nil, fmt.Errorf("inventory %s: %v", path, err)
+	}
+	return inv, nil
+}
+
+func ParseInventory(r io.Reader) (*Inventory, error) {
+	inv := &Inventory{
+		hostIndex: map[string]*InventoryHost{},
+		groups:    map[string]*inventoryGroup{"all": {name: "all", vars: map[string]string{}}},
+	}
+	section, kind := "", ""
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: unterminated section %q", lineNum, line)
+			}
+			section, kind, _ = strings.Cut(line[1:len(line)-1], ":")
+			if section == "" || (kind != "" && kind != "vars" && kind != "children") {
+				return nil, fmt.Errorf("line %d: bad section %q", lineNum, line)
+			}
+			inv.group(section)
+			continue
+		}
+		switch kind {
+		case "vars":
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected key=value", lineNum)
+			}
+			inv.group(section).vars[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		case "children":
+			group := inv.group(section)
+			group.children = append(group.children, line)
+			inv.group(line)
+		default:
+			fields := strings.Fields(line)
+			host := inv.host(fields[0])
+			for _, field := range fields[1:] {
+				key, value, ok := strings.Cut(field, "=")
+				if !ok {
+					return nil, fmt.Errorf("line %d: expected key=value, got %q", lineNum, field)
+				}
+				host.vars[key] = value
+			}
+			if section != "" {
+				group := inv.group(section)
+				group.hosts = append(group.hosts, host.Name)
+				host.groups = append(host.groups, section)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := inv.resolveVars(); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+func (inv *Inventory) group(name string) *inventoryGroup {
+	group := inv.groups[name]
+	if group == nil {
+		group = &inventoryGroup{name: name, vars: map[string]string{}}
+		inv.groups[name] = group
+	}
+	return group
+}
+
+func (inv *Inventory) host(name string) *InventoryHost {
+	host := inv.hostIndex[name]
+	if host == nil {
+		host = &InventoryHost{Name: name, vars: map[string]string{}}
+		inv.hostIndex[name] = host
+		inv.hosts = append(inv.hosts, host)
+	}
+	return host
+}
+
+// groupDepths returns how far below the top every group sits, so that
+// variables of children can override their parents.
+func (inv *Inventory) groupDepths() (map[string]int, error) {
+	parents := map[string][]string{}
+	for _, group := range inv.groups {
+		for _, child := range group.children {
+			parents[child] = append(parents[child], group.name)
+		}
+	}
+	depths := map[string]int{}
+	visiting := map[string]bool{}
+	var depth func(name string) (int, error)
+	depth = func(name string) (int, error) {
+		if d, ok := depths[name]; ok {
+			return d, nil
+		}
+		if visiting[name] {
+			return 0, fmt.Errorf("group %s is its own ancestor", name)
+		}
+		visiting[name] = true
+		d := 0
+		for _, parent := range parents[name] {
+			parentDepth, err := depth(parent)
+			if err != nil {
+				return 0, err
+			}
+			d = max(d, parentDepth+1)
+		}
+		depths[name] = d
+		return d, nil
+	}
+	for name := range inv.groups {
+		if _, err := depth(name); err != nil {
+			return nil, err
+		}
+	}
+	return depths, nil
+}
+
+// groupsOf returns every group containing host, directly or through
+// children, including "all".
+func (inv *Inventory) groupsOf(host *InventoryHost) map[string]bool {
+	member := map[string]bool{"all": true}
+	var add func(name string)
+	add = func(name string) {
+		if member[name] {
+			return
+		}
+		member[name] = true
+		for _, group := range inv.groups {
+			for _, child := range group.children {
+				if child == name {
+					add(group.name)
+				}
+			}
+		}
+	}
+	for _, name := range host.groups {
+		add(name)
+	}
+	return member
+}
+
+func (inv *Inventory) resolveVars() error {
+	depths, err := inv.groupDepths()
+	if err != nil {
+		return err
+	}
+	for _, host := range inv.hosts {
+		groups := []string{}
+		for name := range inv.groupsOf(host) {
+			groups = append(groups, name)
+		}
+		sort.Slice(groups, func(i, j int) bool {
+			if groups[i] == "all" || groups[j] == "all" {
+				return groups[i] == "all" && groups[j] != "all"
+			}
+			if depths[groups[i]] != depths[groups[j]] {
+				return depths[groups[i]] < depths[groups[j]]
+			}
+			return groups[i] < groups[j]
+		})
+		host.Vars = map[string]string{}
+		for _, name := range groups {
+			for key, value := range inv.groups[name].vars {
+				host.Vars[key] = value
+			}
+		}
+		for key, value := range host.vars {
+			host.Vars[key] = value
+		}
+	}
+	return nil
+}
+
+func (inv *Inventory) Hosts() []*InventoryHost {
+	return append([]*InventoryHost{}, inv.hosts...)
+}
+
+// Select returns the hosts matching pattern, in file order. Terms are
+// separated by ':' or ','; each names a group or host and may use
+// wildcards. Plain terms are combined, '&' terms intersect and '!' terms
+// exclude, so "web:&prod:!web3" selects production web servers but web3.
+func (inv *Inventory) Select(pattern string) ([]*InventoryHost, error) {
+	selected := map[string]bool{}
+	var intersections, exclusions [][]*InventoryHost
+	terms := strings.FieldsFunc(pattern, func(r rune) bool {
+		return r == ':' || r == ','
+	})
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("empty host pattern")
+	}
+	for _, term := range terms {
+		op := term[0]
+		if op == '&' || op == '!' {
+			term = term[1:]
+		}
+		hosts, err := inv.matchTerm(term)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case '&':
+			intersections = append(intersections, hosts)
+		case '!':
+			exclusions = append(exclusions, hosts)
+		default:
+			for _, host := range hosts {
+				selected[host.Name] = true
+			}
+		}
+	}
+	for _, hosts := range intersections {
+		keep := map[string]bool{}
+		for _, host := range hosts {
+			keep[host.Name] = selected[host.Name]
+		}
+		selected = keep
+	}
+	for _, hosts := range exclusions {
+		for _, host := range hosts {
+			delete(selected, host.Name)
+		}
+	}
+	result := []*InventoryHost{}
+	for _, host := range inv.hosts {
+		if selected[host.Name] {
+			result = append(result, host)
+		}
+	}
+	return result, nil
+}
+
+func (inv *Inventory) matchTerm(term string) ([]*InventoryHost, error) {
+	if term == "" {
+		return nil, fmt.Errorf("empty term in host pattern")
+	}
+	if term == "*" {
+		term = "all"
+	}
+	matched := []*InventoryHost{}
+	for _, host := range inv.hosts {
+		match := wildcardMatch(term, host.Name)
+		for name := range inv.groupsOf(host) {
+			match = match || wildcardMatch(term, name)
+		}
+		if match {
+			matched = append(matched, host)
+		}
+	}
+	// An empty group is a valid selection, an unknown name a typo.
+	if _, ok := inv.groups[term]; !ok && len(matched) == 0 {
+		return nil, fmt.Errorf("host pattern %q matches no host or group", term)
+	}
+	return matched, nil
+}
+
+// ClientConfigs selects hosts with pattern and returns their configs.
+func (inv *Inventory) ClientConfigs(pattern string) ([]ClientConfig, error) {
+	hosts, err := inv.Select(pattern)
+	if err != nil {
+		return nil, err
+	}
+	configs := []ClientConfig{}
+	for _, host := range hosts {
+		config, err := inv.ClientConfig(host)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
+// ClientConfig builds a config from the variables host (address in any
+// ParseTarget form, defaulting to the host name), user, port, key, jump
+// (an inventory host or a target) and sudo_password. The sudo password is
+// a reference resolved with ResolveSecret; it becomes ClientConfig.Password,
+// which answers sudo prompts.
+func (inv *Inventory) ClientConfig(host *InventoryHost) (ClientConfig, error) {
+	return inv.clientConfig(host, 0)
+}
+
+func (inv *Inventory) clientConfig(host *InventoryHost, depth int) (ClientConfig, error) {
+	if depth > maxInventoryJumpDepth {
+		return ClientConfig{}, fmt.Errorf("inventory host %s: jump chain is too long", host.Name)
+	}
+	address := host.Vars["host"]
+	if address == "" {
+		address = host.Name
+	}
+	target, err := ParseTarget(address)
+	if err != nil {
+		return ClientConfig{}, fmt.Errorf("inventory host %s: %v", host.Name, err)
+	}
+	config := ClientConfig{
+		User: target.User,
+		Host: target.Host,
+		Port: target.Port,
+	}
+	if user := host.Vars["user"]; user != "" && config.User == "" {
+		config.User = user
+	}
+	if port := host.Vars["port"]; port != "" && config.Port == "" {
+		config.Port = port
+	}
+	if key := host.Vars["key"]; key != "" {
+		config.PrivateKeyFile = expandHomeDir(key)
+	}
+	if ref := host.Vars["sudo_password"]; ref != "" {
+		password, err := ResolveSecret(ref)
+		if err != nil {
+			return ClientConfig{}, fmt.Errorf("inventory host %s: sudo_password: %v", host.Name, err)
+		}
+		config.Password = password
+	}
+	if jump := host.Vars["jump"]; jump != "" && jump != host.Name {
+		var jumpConfig ClientConfig
+		if jumpHost := inv.hostIndex[jump]; jumpHost != nil {
+			jumpConfig, err = inv.clientConfig(jumpHost, depth+1)
+		} else {
+			jumpConfig, err = inv.clientConfig(&InventoryHost{Name: jump, Vars: map[string]string{}}, depth+1)
+		}
+		if err != nil {
+			return ClientConfig{}, err
+		}
+		config.Jump = &jumpConfig
+	}
+	return config, nil
+}
+
+// ResolveSecret reads a secret reference: "env:NAME" reads an environment
+// variable and "file:PATH" the first line of a file. Plain values are
+// refused so that inventories never hold passwords.
+func ResolveSecret(ref string) (string, error) {
+	kind, name, _ := strings.Cut(ref, ":")
+	switch kind {
+	case "env":
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value, nil
+	case "file":
+		content, err := os.ReadFile(expandHomeDir(name))
+		if err != nil {
+			return "", err
+		}
+		line, _, _ := strings.Cut(string(content), "\n")
+		return strings.TrimRight(line, "\r"), nil
+	}
+	return "", fmt.Errorf("unsupported secret reference %q, use env:NAME or file:PATH", ref)
+}
@@ -0,0 +1,178 @@
+package sshclient
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testInventory = `
+# ungrouped
+bastion host=ops@203.0.113.10
+
+[web]
+web1 host=10.0.0.1
+web2 host=10.0.0.2 port=2222
+web3 host=10.0.0.3 user=root
+
+[db]
+db1 host=10.0.1.1
+
+[web:vars]
+user=deploy
+key=~/.ssh/deploy
+jump=bastion
+
+[prod:children]
+web
+db
+
+[prod:vars]
+user=ops
+port=2200
+sudo_password=env:TEST_INVENTORY_SUDO
+
+[all:vars]
+user=nobody
+`
+
+func testInventoryHosts(t *testing.T, inv *Inventory, pattern string) string {
+	t.Helper()
+	hosts, err := inv.Select(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, host := range hosts {
+		names = append(names, host.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestInventorySelectPatterns(t *testing.T) {
+	inv, err := ParseInventory(strings.NewReader(testInventory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for pattern, want := range map[string]string{
+		"all":             "bastion,web1,web2,web3,db1",
+		"web":             "web1,web2,web3",
+		"web:&prod:!web3": "web1,web2",
+		"prod:!web":       "db1",
+		"web?,bastion":    "bastion,web1,web2,web3",
+		"*:!prod":         "bastion",
+		"db1:web2":        "web2,db1",
+	} {
+		if got := testInventoryHosts(t, inv, pattern); got != want {
+			t.Errorf("Select(%q) = %s, want %s", pattern, got, want)
+		}
+	}
+	if _, err := inv.Select("web:&staging"); err == nil {
+		t.Fatal("expected an error for an unknown group")
+	}
+}
+
+func TestInventoryVariablePrecedence(t *testing.T) {
+	t.Setenv("TEST_INVENTORY_SUDO", "hunter2")
+	inv, err := ParseInventory(strings.NewReader(testInventory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs, err := inv.ClientConfigs("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	home, _ := os.UserHomeDir()
+	web1, web2, web3 := configs[0], configs[1], configs[2]
+	if web1.User != "deploy" || web1.Host != "10.0.0.1" || web1.Port != "2200" || web1.Password != "hunter2" {
+		t.Fatalf("web1 = %+v", web1)
+	}
+	if web1.PrivateKeyFile != filepath.Join(home, ".ssh/deploy") {
+		t.Fatalf("web1 key = %s", web1.PrivateKeyFile)
+	}
+	if web2.Port != "2222" || web3.User != "root" {
+		t.Fatalf("host variables must win: web2 %+v, web3 %+v", web2, web3)
+	}
+	if web1.Jump == nil || web1.Jump.User != "ops" || web1.Jump.Host != "203.0.113.10" || web1.Jump.Jump != nil {
+		t.Fatalf("web1 jump = %+v", web1.Jump)
+	}
+
+	db, err := inv.ClientConfigs("db1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db[0].User != "ops" || db[0].Jump != nil {
+		t.Fatalf("db1 = %+v", db[0])
+	}
+}
+
+func TestInventoryRejectsBadInput(t *testing.T) {
+	for _, content := range []string{
+		"[web",
+		"[web:hosts]",
+		"[web]\nweb1 port",
+		"[a:children]\nb\n[b:children]\na",
+	} {
+		if _, err := ParseInventory(strings.NewReader(content)); err == nil {
+			t.Errorf("expected an error for %q", content)
+		}
+	}
+
+	inv, err := ParseInventory(strings.NewReader("web1 sudo_password=hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inv.ClientConfigs("all"); err == nil {
+		t.Fatal("plain sudo passwords must be refused")
+	}
+}
+
+func TestInventoryConfigDialsThroughJumpHost(t *testing.T) {
+	bastion := newTestSSHServer(t, testSigner(t))
+	serveDirectTCPIP(bastion)
+	target := newTestSSHServer(t, testSigner(t))
+	serveExec(target)
+
+	secret := filepath.Join(t.TempDir(), "sudo")
+	if err := os.WriteFile(secret, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	inv, err := ParseInventory(strings.NewReader(`
+bastion host=` + bastion.addr + `
+
+[app]
+app1 host=` + target.addr + `
+
+[app:vars]
+jump=bastion
+
+[all:vars]
+user=test
+sudo_password=file:` + secret + `
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs, err := inv.ClientConfigs("app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := configs[0]
+	config.SSHFolderPath = t.TempDir()
+	config.Jump.SSHFolderPath = config.SSHFolderPath
+
+	client, err := Dial(context.Background(), config, WithGlobalKnownHostsFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.closeConnection()
+	if client.jumpClient == nil {
+		t.Fatal("expected the client to go through the jump host")
+	}
+	result := &CommandResult{}
+	if err := client.runCommand(context.Background(), "echo hi", result); err != nil || string(result.Stdout) != "hi\n" {
+		t.Fatalf("runCommand = %q, %v", result.Stdout, err)
+	}
+}
@@ -0,0 +1,116 @@
+package sshclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	keepaliveRequest = "keepalive@openssh.com"
+
+	DefaultKeepaliveMaxMissed = 3
+	DefaultPingTimeout        = 15 * time.Second
+)
+
+// TimeoutPhaseKeepalive is reported when a keepalive or Ping gets no reply.
+const TimeoutPhaseKeepalive = "keepalive"
+
+// startKeepalive sends a keepalive every interval, like ServerAliveInterval.
+// Only one request is outstanding at a time; every interval that passes
+// without its reply counts as missed, and after maxMissed the connection is
+// closed so that sessions in flight fail instead of hanging.
+func (c *Client) startKeepalive(client *ssh.Client, closed <-chan struct{}) {
+	interval := c.keepaliveInterval
+	if interval <= 0 {
+		return
+	}
+	maxMissed := c.keepaliveMaxMissed
+	if maxMissed <= 0 {
+		maxMissed = DefaultKeepaliveMaxMissed
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var reply chan error
+		missed := 0
+		for {
+			select {
+			case <-closed:
+				return
+			case <-ticker.C:
+			}
+			if reply != nil {
+				select {
+				case err := <-reply:
+					if err != nil {
+						return
+					}
+					missed = 0
+				default:
+					missed++
+					if missed >= maxMissed {
+						c.log("WARNING: %s did not answer %d keepalives, closing the connection.", c.addr(), missed)
+						client.Close()
+						return
+					}
+					continue
+				}
+			}
+			reply = make(chan error, 1)
+			go func(reply chan error) {
+				reply <- sendKeepalive(client, closed)
+			}(reply)
+		}
+	}()
+}
+
+// Ping sends a keepalive and reports the round-trip time. Any reply counts,
+// including a refusal, since it proves the server is alive. It waits up to
+// DefaultPingTimeout for the reply whatever the keepalive interval is.
+func (c *Client) Ping() (time.Duration, error) {
+	return c.ping(DefaultPingTimeout)
+}
+
+func (c *Client) ping(timeout time.Duration) (time.Duration, error) {
+	client, closed, err := c.liveConnection(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	err = runWithTimeout(TimeoutPhaseKeepalive, c.addr(), timeout, func() {}, func() error {
+		// The connection may close between the check in sendKeepalive
+		// and the request, so stop waiting as soon as it does.
+		reply := make(chan error, 1)
+		go func() {
+			reply <- sendKeepalive(client, closed)
+		}()
+		select {
+		case err := <-reply:
+			return err
+		case <-closed:
+			return net.ErrClosed
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+	c.sshPrint(fmt.Sprintf("Ping done took %s", rtt))
+	return rtt, nil
+}
+
+// sendKeepalive refuses to use a closed connection, on which SendRequest
+// would spin on its closed response channel instead of failing.
+func sendKeepalive(client *ssh.Client, closed <-chan struct{}) error {
+	select {
+	case <-closed:
+		return net.ErrClosed
+	default:
+	}
+	_, _, err := client.SendRequest(keepaliveRequest, true, nil)
+	return err
+}
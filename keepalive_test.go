@@ -0,0 +1,99 @@
+package sshclient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestPingReportsRoundTrip(t *testing.T) {
+	server := newTestSSHServer(t, testSigner(t))
+	client, err := Dial(context.Background(), ClientConfig{
+		User:          "test",
+		Password:      "secret",
+		Host:          server.addr,
+		SSHFolderPath: t.TempDir(),
+	}, WithGlobalKnownHostsFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.closeConnection()
+
+	rtt, err := client.Ping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rtt <= 0 {
+		t.Fatalf("unexpected round trip %s", rtt)
+	}
+}
+
+func TestKeepaliveClosesDeadConnection(t *testing.T) {
+	server := newTestSSHServer(t, testSigner(t))
+	server.handleConn = func(conn *ssh.ServerConn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request) {
+		// Never answer global requests, like a peer behind a dead NAT.
+		go func() {
+			for range chans {
+			}
+		}()
+		conn.Wait()
+	}
+	client, err := Dial(context.Background(), ClientConfig{
+		User:               "test",
+		Password:           "secret",
+		Host:               server.addr,
+		SSHFolderPath:      t.TempDir(),
+		KeepaliveInterval:  20 * time.Millisecond,
+		KeepaliveMaxMissed: 2,
+	}, WithGlobalKnownHostsFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.closeConnection()
+
+	closed := make(chan struct{})
+	go func() {
+		client.client.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("keepalive did not close the dead connection")
+	}
+	if _, err := client.Ping(); err == nil {
+		t.Fatal("Ping should fail on a closed connection")
+	}
+}
+
+func TestPingTimeoutIsNotKeepaliveInterval(t *testing.T) {
+	server := newTestSSHServer(t, testSigner(t))
+	server.handleConn = func(conn *ssh.ServerConn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request) {
+		go func() {
+			for range chans {
+			}
+		}()
+		for req := range reqs {
+			time.Sleep(50 * time.Millisecond)
+			req.Reply(false, nil)
+		}
+	}
+	client, err := Dial(context.Background(), ClientConfig{
+		User:               "test",
+		Password:           "secret",
+		Host:               server.addr,
+		SSHFolderPath:      t.TempDir(),
+		KeepaliveInterval:  10 * time.Millisecond,
+		KeepaliveMaxMissed: 1000,
+	}, WithGlobalKnownHostsFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.closeConnection()
+
+	if _, err := client.Ping(); err != nil {
+		t.Fatalf("Ping gave up before a slow reply: %v", err)
+	}
+}
@@ -1,8 +1,10 @@
 package sshclient
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"time"
 
@@ -11,29 +13,64 @@ import (
 
 func (c *Client) getAuthMethodPublicKeys() (authMethod ssh.AuthMethod, err error) {
 	start := time.Now()
-	sshPrint("getAuthMethodPublicKeys start")
-	keyFilePath := filepath.Join(c.getSSHFolderPath(), "id_rsa")
-	if c.sshKeyPem != "" {
-		keyFilePath = c.sshKeyPem
+	c.sshPrint("getAuthMethodPublicKeys start")
+	if c.sshKeyPem == "" {
+		signers := c.identitySigners()
+		c.sshPrint(fmt.Sprintf("getAuthMethodPublicKeys done took %s", time.Since(start)))
+		if len(signers) == 0 {
+			return nil, nil
+		}
+		return ssh.PublicKeys(signers...), nil
 	}
+	keyFilePath := c.sshKeyPem
 	readStart := time.Now()
-	sshPrint("ReadFile id_rsa start")
+	c.sshPrint("ReadFile id_rsa start")
 	key, err := ioutil.ReadFile(keyFilePath)
-	sshPrint(fmt.Sprintf("ReadFile id_rsa done took %s", time.Since(readStart)))
+	c.sshPrint(fmt.Sprintf("ReadFile id_rsa done took %s", time.Since(readStart)))
 	if err != nil {
-		sshPrint(fmt.Sprintf("getAuthMethodPublicKeys error took %s", time.Since(start)))
+		c.sshPrint(fmt.Sprintf("getAuthMethodPublicKeys error took %s", time.Since(start)))
 		return nil, err
 	}
 
 	parseStart := time.Now()
-	sshPrint("ParsePrivateKey start")
+	c.sshPrint("ParsePrivateKey start")
 	signer, err := ssh.ParsePrivateKey(key)
-	sshPrint(fmt.Sprintf("ParsePrivateKey done took %s", time.Since(parseStart)))
+	c.sshPrint(fmt.Sprintf("ParsePrivateKey done took %s", time.Since(parseStart)))
 	if err != nil {
-		sshPrint(fmt.Sprintf("getAuthMethodPublicKeys error took %s", time.Since(start)))
+		c.sshPrint(fmt.Sprintf("getAuthMethodPublicKeys error took %s", time.Since(start)))
 		return nil, err
 	}
 
-	sshPrint(fmt.Sprintf("getAuthMethodPublicKeys done took %s", time.Since(start)))
+	c.sshPrint(fmt.Sprintf("getAuthMethodPublicKeys done took %s", time.Since(start)))
 	return ssh.PublicKeys(signer), nil
 }
+
+// identitySigners loads every identity file that exists, defaulting to the
+// usual key names in the ssh folder. Missing files and
+// keys that need a passphrase are skipped, as ssh does with IdentityFile.
+func (c *Client) identitySigners() []ssh.Signer {
+	identityFiles := c.identityFiles
+	if len(identityFiles) == 0 {
+		for _, name := range []string{"id_rsa", "id_ecdsa", "id_ed25519"} {
+			identityFiles = append(identityFiles, filepath.Join(c.getSSHFolderPath(), name))
+		}
+	}
+	signers := []ssh.Signer{}
+	for _, path := range identityFiles {
+		key, err := ioutil.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			c.log("WARNING: skipping identity file %s: %v", path, err)
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			c.log("WARNING: skipping identity file %s: %v", path, err)
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	return signers
+}
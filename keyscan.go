@@ -0,0 +1,187 @@
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var errScanDone = errors.New("host key collected")
+
+// Every key is scanned within these limits, or earlier when ctx expires.
+var (
+	scanConnectTimeout   = DefaultConnectTimeout
+	scanHandshakeTimeout = DefaultHandshakeTimeout
+)
+
+func defaultScanAlgorithms() []string {
+	return []string{
+		ssh.KeyAlgoED25519,
+		ssh.KeyAlgoECDSA256,
+		ssh.KeyAlgoECDSA384,
+		ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSASHA512,
+	}
+}
+
+type ScannedHostKey struct {
+	Host      string
+	Remote    net.Addr
+	Algorithm string
+	Key       ssh.PublicKey
+}
+
+func (k ScannedHostKey) Fingerprint() string {
+	return ssh.FingerprintSHA256(k.Key)
+}
+
+func (k ScannedHostKey) String() string {
+	return knownhosts.Line([]string{k.Host}, k.Key)
+}
+
+// ScanHostKeys collects the host keys offered by every address, like
+// ssh-keyscan. One handshake is made per key algorithm and aborted as soon
+// as the server has presented its key, so no authentication takes place.
+// Addresses default to port 22 and algos to every common key type.
+// Unreachable addresses and servers that do not answer within
+// DefaultConnectTimeout and DefaultHandshakeTimeout are reported in the
+// returned error while the keys of the other addresses are still returned.
+// Of opts only WithLogger applies.
+func ScanHostKeys(ctx context.Context, addrs []string, algos []string, opts ...ClientOption) ([]ScannedHostKey, error) {
+	if len(algos) == 0 {
+		algos = defaultScanAlgorithms()
+	}
+	log := optionsLog(opts)
+	results := make([][]ScannedHostKey, len(addrs))
+	errs := make([]error, len(addrs))
+	wg := sync.WaitGroup{}
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			results[i], errs[i] = log.scanHostKeys(ctx, addr, algos)
+		}(i, addr)
+	}
+	wg.Wait()
+
+	keys := []ScannedHostKey{}
+	for _, result := range results {
+		keys = append(keys, result...)
+	}
+	return keys, errors.Join(errs...)
+}
+
+func (c *Client) scanHostKeys(ctx context.Context, addr string, algos []string) ([]ScannedHostKey, error) {
+	start := time.Now()
+	c.sshPrint(fmt.Sprintf("scanHostKeys start addr=%s", addr))
+	target, err := ParseTarget(addr)
+	if err != nil {
+		return nil, err
+	}
+	addr = target.Addr()
+	keys := []ScannedHostKey{}
+	var scanErr error
+	for _, algo := range algos {
+		key, err := scanHostKey(ctx, addr, algo)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) || ctx.Err() != nil {
+				scanErr = fmt.Errorf("scan %s: %v", addr, err)
+				break
+			}
+			// The server does not offer this algorithm.
+			continue
+		}
+		if scannedKeyExists(keys, key.Key) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	c.sshPrint(fmt.Sprintf("scanHostKeys done addr=%s keys=%d took %s", addr, len(keys), time.Since(start)))
+	return keys, scanErr
+}
+
+func scannedKeyExists(keys []ScannedHostKey, key ssh.PublicKey) bool {
+	for _, existing := range keys {
+		if bytes.Equal(existing.Key.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+func scanHostKey(ctx context.Context, addr, algo string) (ScannedHostKey, error) {
+	dialer := &net.Dialer{Timeout: scanConnectTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return ScannedHostKey{}, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(scanHandshakeTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	scanned := ScannedHostKey{Host: normalizeHost(addr), Algorithm: algo}
+	config := &ssh.ClientConfig{
+		User:              "keyscan",
+		HostKeyAlgorithms: []string{algo},
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			scanned.Remote = remote
+			scanned.Key = key
+			return errScanDone
+		},
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err == nil {
+		ssh.NewClient(c, chans, reqs).Close()
+	}
+	if scanned.Key != nil {
+		return scanned, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("no host key received for %s", algo)
+	}
+	return ScannedHostKey{}, err
+}
+
+// WriteScannedHostKeys records scanned keys through the same path used for
+// new hosts on connect: the client's store, hashing and revocation options
+// apply and keys already present are not written twice.
+func WriteScannedHostKeys(keys []ScannedHostKey, opts ...ClientOption) error {
+	c := &Client{}
+	c.applyOptions(opts)
+	for _, key := range keys {
+		if err := c.addHostKey(key.Host, key.Remote, key.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) addHostKey(host string, remote net.Addr, pubKey ssh.PublicKey) error {
+	store := c.getHostKeyStore()
+	kh, ok := store.(*KnownHosts)
+	if !ok {
+		if err := c.checkRevokedHostKey(host, pubKey, nil); err != nil {
+			return err
+		}
+		return store.Add(uniqueKnownHostsAddrs(host, remote), pubKey)
+	}
+	return kh.withLockedFile(func(f *os.File, lines []knownHostLine) error {
+		return c.appendHostKey(f, host, remote, pubKey)
+	})
+}
@@ -0,0 +1,119 @@
+package sshclient
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func testECDSASigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestScanHostKeysCollectsEveryKeyType(t *testing.T) {
+	edKey := testSigner(t)
+	ecKey := testECDSASigner(t)
+	server := newTestSSHServer(t, edKey, ecKey)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	logger := &testLogger{}
+	keys, err := ScanHostKeys(ctx, []string{server.addr}, nil, WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %v", keys)
+	}
+	if !logger.contains("ssh scanHostKeys done") {
+		t.Fatalf("scan did not log to the logger: %v", logger.lines)
+	}
+	if keys[0].Fingerprint() != ssh.FingerprintSHA256(edKey.PublicKey()) || keys[1].Fingerprint() != ssh.FingerprintSHA256(ecKey.PublicKey()) {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	for i := 0; i < 2; i++ {
+		if err := WriteScannedHostKeys(keys, WithKnownHostsFile(path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := NewKnownHosts(path).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %v", entries)
+	}
+
+	c := &Client{userKnownHostsFile: path, globalKnownHostsFiles: []string{}, hostKeyPolicy: HostKeyPolicyStrict}
+	if err := c.hostKeyCallback(server.addr, keys[1].Remote, ecKey.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanHostKeysReportsUnreachableAddress(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	server := newTestSSHServer(t, testSigner(t))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	keys, err := ScanHostKeys(ctx, []string{addr, server.addr}, []string{ssh.KeyAlgoED25519})
+	if err == nil {
+		t.Fatal("expected error for unreachable address")
+	}
+	if len(keys) != 1 {
+		t.Fatalf("expected key of reachable server, got %v", keys)
+	}
+}
+
+func TestScanHostKeysTimesOutSilentServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		// Accept but never speak SSH.
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	defer func(timeout time.Duration) { scanHandshakeTimeout = timeout }(scanHandshakeTimeout)
+	scanHandshakeTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	_, err = ScanHostKeys(context.Background(), []string{listener.Addr().String()}, nil)
+	if err == nil || !strings.Contains(err.Error(), "i/o timeout") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("scan took %s", time.Since(start))
+	}
+}
@@ -32,48 +32,99 @@ func (k *fakePublicKey) Verify(data []byte, sig *ssh.Signature) error {
 	return errors.New("fake public key")
 }
 
-func (c *Client) createKnownHosts() {
-	f, err := os.OpenFile(filepath.Join(c.getSSHFolderPath(), "known_hosts"), os.O_CREATE, 0600)
-	if err != nil {
-		panic(err)
+func (c *Client) knownHostsPath() string {
+	if c.userKnownHostsFile != "" {
+		return c.userKnownHostsFile
 	}
-	f.Close()
+	return filepath.Join(c.getSSHFolderPath(), "known_hosts")
 }
 
-func (c *Client) checkKnownHosts() ssh.HostKeyCallback {
-	start := time.Now()
-	sshPrint("checkKnownHosts start")
-	c.createKnownHosts()
-	parseStart := time.Now()
-	sshPrint("knownhosts.New start")
-	kh, err := knownhosts.New(filepath.Join(c.getSSHFolderPath(), "known_hosts"))
-	sshPrint(fmt.Sprintf("knownhosts.New done took %s", time.Since(parseStart)))
+func defaultGlobalKnownHostsFiles() []string {
+	return []string{"/etc/ssh/ssh_known_hosts", "/etc/ssh/ssh_known_hosts2"}
+}
+
+func (c *Client) getGlobalKnownHostsFiles() []string {
+	if c.globalKnownHostsFiles == nil {
+		return defaultGlobalKnownHostsFiles()
+	}
+	return c.globalKnownHostsFiles
+}
+
+// createKnownHosts returns errors instead of panicking since it runs in
+// the host key callback, where nothing could recover a panic.
+func (c *Client) createKnownHosts() error {
+	f, err := os.OpenFile(c.knownHostsPath(), os.O_CREATE, 0600)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	sshPrint(fmt.Sprintf("checkKnownHosts done took %s", time.Since(start)))
-	return kh
+	return f.Close()
 }
 
 func (c *Client) hostKeyAlgorithms(hostWithPort string) []string {
-	start := time.Now()
-	sshPrint("hostKeyAlgorithms lock start")
-	knownHostsMu.Lock()
-	sshPrint(fmt.Sprintf("hostKeyAlgorithms lock done took %s", time.Since(start)))
-	defer knownHostsMu.Unlock()
+	if !c.usesKnownHosts() || len(c.pinsForHost(hostWithPort)) > 0 {
+		return nil
+	}
+	snapshot, err := c.hostKeySnapshot(hostWithPort)
+	if err != nil {
+		c.log("WARNING: cannot read known host keys for %s: %v", hostWithPort, err)
+		return nil
+	}
+	algos := c.hostKeyAlgorithmsFromCallback(snapshot.callback(), hostWithPort, certAuthorityKeys(snapshot))
+	return withHostCertAlgorithms(snapshot.lines, hostWithPort, algos)
+}
+
+var hostCertAlgorithms = []string{
+	ssh.CertAlgoED25519v01,
+	ssh.CertAlgoECDSA256v01,
+	ssh.CertAlgoECDSA384v01,
+	ssh.CertAlgoECDSA521v01,
+	ssh.CertAlgoRSASHA256v01,
+	ssh.CertAlgoRSASHA512v01,
+}
+
+func knownKeyID(known knownhosts.KnownKey) string {
+	return fmt.Sprintf("%s:%d", known.Filename, known.Line)
+}
+
+func certAuthorityKeys(snapshot *knownHostsSnapshot) map[string]bool {
+	ids := map[string]bool{}
+	for _, line := range snapshot.lines {
+		if line.marker == knownHostsMarkerCert {
+			ids[knownKeyID(snapshot.knownKey(line))] = true
+		}
+	}
+	return ids
+}
 
-	return hostKeyAlgorithmsFromCallback(c.checkKnownHosts(), hostWithPort)
+func hasHostCertAuthority(lines []knownHostLine, hostWithPort string) bool {
+	for _, line := range lines {
+		if line.marker == knownHostsMarkerCert && line.matchesHost(hostWithPort) {
+			return true
+		}
+	}
+	return false
 }
 
-func hostKeyAlgorithmsFromCallback(kh ssh.HostKeyCallback, hostWithPort string) []string {
+// withHostCertAlgorithms puts certificate algorithms in front of the
+// plain key algorithms when a @cert-authority line covers the host, so
+// the server presents its certificate instead of a bare key. A nil list
+// keeps the library defaults, which already prefer certificates.
+func withHostCertAlgorithms(lines []knownHostLine, hostWithPort string, algos []string) []string {
+	if len(algos) == 0 || !hasHostCertAuthority(lines, hostWithPort) {
+		return algos
+	}
+	return append(append([]string{}, hostCertAlgorithms...), algos...)
+}
+
+func (c *Client) hostKeyAlgorithmsFromCallback(kh ssh.HostKeyCallback, hostWithPort string, certKeys map[string]bool) []string {
 	start := time.Now()
-	sshPrint("hostKeyAlgorithmsFromCallback start")
+	c.sshPrint("hostKeyAlgorithmsFromCallback start")
 	defer func() {
-		sshPrint(fmt.Sprintf("hostKeyAlgorithmsFromCallback done took %s", time.Since(start)))
+		c.sshPrint(fmt.Sprintf("hostKeyAlgorithmsFromCallback done took %s", time.Since(start)))
 	}()
 	dummy := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
 	err := kh(hostWithPort, dummy, &fakePublicKey{})
-	sshPrint(fmt.Sprintf("hostKeyAlgorithmsFromCallback lookup took %s", time.Since(start)))
+	c.sshPrint(fmt.Sprintf("hostKeyAlgorithmsFromCallback lookup took %s", time.Since(start)))
 	if err == nil {
 		return nil
 	}
@@ -85,6 +136,9 @@ func hostKeyAlgorithmsFromCallback(kh ssh.HostKeyCallback, hostWithPort string)
 	seen := map[string]bool{}
 	algos := []string{}
 	for _, known := range keyErr.Want {
+		if certKeys[knownKeyID(known)] {
+			continue
+		}
 		for _, algo := range algorithmsForKeyType(known.Key.Type()) {
 			if seen[algo] {
 				continue
@@ -108,57 +162,132 @@ func algorithmsForKeyType(keyType string) []string {
 
 func (c *Client) hostKeyCallback(host string, remote net.Addr, pubKey ssh.PublicKey) error {
 	start := time.Now()
-	sshPrint("hostKeyCallback start")
+	c.sshPrint("hostKeyCallback start")
 	defer func() {
-		sshPrint(fmt.Sprintf("hostKeyCallback done took %s", time.Since(start)))
+		c.sshPrint(fmt.Sprintf("hostKeyCallback done took %s", time.Since(start)))
 	}()
+	// Pinned hosts are verified against their pins under every policy,
+	// including the insecure one.
+	if pins := c.pinsForHost(host); len(pins) > 0 || c.hostKeyPolicy == HostKeyPolicyPinned {
+		return c.checkPinnedHostKey(host, pubKey, pins)
+	}
+	if c.hostKeyPolicy == HostKeyPolicyInsecure {
+		return c.checkInsecureHostKey(host, pubKey)
+	}
+	if c.hostKeyStore == nil {
+		if err := c.createKnownHosts(); err != nil {
+			c.log("WARNING: cannot create known_hosts: %v", err)
+			return err
+		}
+	}
+	snapshot, err := c.hostKeySnapshot(host)
+	if err != nil {
+		return err
+	}
+	keyErr, err := c.lookupHostKey(snapshot, host, remote, pubKey)
+	if c.hostKeyApprover != nil {
+		var changed *knownhosts.KeyError
+		if keyErr == nil && errors.As(err, &changed) && len(changed.Want) > 0 {
+			keyErr = changed
+		}
+		if keyErr != nil {
+			return c.approveHostKey(host, remote, pubKey, keyErr)
+		}
+	}
+	if err != nil || keyErr == nil {
+		return err
+	}
+
+	if c.hostKeyPolicy == HostKeyPolicyStrict {
+		c.log("WARNING: %s is not in known_hosts and the host key policy is strict, rejecting it.", host)
+		return keyErr
+	}
+
+	store := c.getHostKeyStore()
+	if kh, ok := store.(*KnownHosts); ok {
+		return c.appendKnownHostsFile(kh.Path(), host, remote, pubKey)
+	}
+	c.log("WARNING: %s is not trusted, adding this key: %s %s to %s.", host, pubKey.Type(), ssh.FingerprintSHA256(pubKey), hostKeyStoreName(store))
+	return store.Add(uniqueKnownHostsAddrs(host, remote), pubKey)
+}
+
+func (c *Client) appendKnownHostsFile(khFilePath, host string, remote net.Addr, pubKey ssh.PublicKey) error {
 	lockStart := time.Now()
-	sshPrint("hostKeyCallback mutex lock start")
-	knownHostsMu.Lock()
-	sshPrint(fmt.Sprintf("hostKeyCallback mutex lock done took %s", time.Since(lockStart)))
-	defer knownHostsMu.Unlock()
+	c.sshPrint("known_hosts lock start")
+	unlock, err := lockKnownHosts(khFilePath)
+	if err != nil {
+		return err
+	}
+	c.sshPrint(fmt.Sprintf("known_hosts lock done took %s", time.Since(lockStart)))
+	defer unlock()
 
-	c.createKnownHosts()
-	khFilePath := filepath.Join(c.getSSHFolderPath(), "known_hosts")
 	f, err := os.OpenFile(khFilePath, os.O_RDWR|os.O_CREATE, 0600)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	flockStart := time.Now()
-	sshPrint("lockKnownHostsFile start")
-	if err := lockKnownHostsFile(f); err != nil {
+
+	// Another goroutine or process may have written the host while we
+	// waited for the locks, so check again against the file as it is now.
+	content, err := readKnownHostsFile(f)
+	if err != nil {
+		return err
+	}
+	keyErr, err := c.lookupHostKey(newKnownHostsSnapshot(khFilePath, content), host, remote, pubKey)
+	if err != nil || keyErr == nil {
 		return err
 	}
-	sshPrint(fmt.Sprintf("lockKnownHostsFile done took %s", time.Since(flockStart)))
-	defer unlockKnownHostsFile(f)
 
-	kh := c.checkKnownHosts()
+	c.log("WARNING: %s is not trusted, adding this key: %s %s to known_hosts file.", host, pubKey.Type(), ssh.FingerprintSHA256(pubKey))
+	appendStart := time.Now()
+	c.sshPrint("appendHostKey start")
+	err = c.appendHostKey(f, host, remote, pubKey)
+	c.sshPrint(fmt.Sprintf("appendHostKey done took %s", time.Since(appendStart)))
+	return err
+}
+
+// lookupHostKey checks pubKey against a known_hosts snapshot. It returns
+// a nil error and nil KeyError when the key is trusted, a non-nil error
+// when it must be rejected, and the KeyError alone when the host is
+// simply unknown.
+func (c *Client) lookupHostKey(snapshot *knownHostsSnapshot, host string, remote net.Addr, pubKey ssh.PublicKey) (*knownhosts.KeyError, error) {
+	if err := c.checkRevokedHostKey(host, pubKey, snapshot.lines); err != nil {
+		c.log("WARNING: refusing host key of %s: %v", host, err)
+		return nil, err
+	}
+
 	lookupStart := time.Now()
-	sshPrint("known_hosts lookup start")
-	hErr := kh(host, remote, pubKey)
-	sshPrint(fmt.Sprintf("known_hosts lookup done took %s", time.Since(lookupStart)))
+	c.sshPrint("known_hosts lookup start")
+	hErr := snapshot.callback()(host, remote, pubKey)
+	c.sshPrint(fmt.Sprintf("known_hosts lookup done took %s", time.Since(lookupStart)))
 	if hErr == nil {
-		Log("Pub key exists for %s.", host)
-		return nil
+		c.log("Pub key exists for %s.", host)
+		return nil, nil
+	}
+
+	if _, ok := pubKey.(*ssh.Certificate); ok {
+		c.log("WARNING: host certificate of %s was not accepted: %v", host, hErr)
+		return nil, hErr
 	}
 
 	var keyErr *knownhosts.KeyError
 	if !errors.As(hErr, &keyErr) {
-		return hErr
+		return nil, hErr
 	}
 
 	if len(keyErr.Want) > 0 {
-		Log("WARNING: %v is not a key of %s, either a MiTM attack or %s has reconfigured the host pub key.", string(pubKey.Marshal()), host, host)
-		return keyErr
+		c.log("WARNING: %s is not a key of %s (known: %s), either a MiTM attack or %s has reconfigured the host pub key.", ssh.FingerprintSHA256(pubKey), host, strings.Join(knownKeyFingerprints(keyErr.Want), ", "), host)
+		return nil, keyErr
 	}
+	return keyErr, nil
+}
 
-	Log("WARNING: %s is not trusted, adding this key: %q to known_hosts file.", host, string(pubKey.Marshal()))
-	appendStart := time.Now()
-	sshPrint("appendHostKey start")
-	err = c.appendHostKey(f, host, remote, pubKey)
-	sshPrint(fmt.Sprintf("appendHostKey done took %s", time.Since(appendStart)))
-	return err
+func knownKeyFingerprints(knownKeys []knownhosts.KnownKey) []string {
+	fingerprints := []string{}
+	for _, known := range knownKeys {
+		fingerprints = append(fingerprints, ssh.FingerprintSHA256(known.Key))
+	}
+	return fingerprints
 }
 
 func uniqueKnownHostsAddrs(host string, remote net.Addr) []string {
@@ -168,7 +297,7 @@ func uniqueKnownHostsAddrs(host string, remote net.Addr) []string {
 		if addr == "" {
 			return
 		}
-		normalized := knownhosts.Normalize(addr)
+		normalized := normalizeHost(addr)
 		if normalized == "" || seen[normalized] {
 			return
 		}
@@ -207,12 +336,20 @@ func hostKeyAlreadyStored(existing string, addrs []string, pubKey ssh.PublicKey)
 		if len(fields) < 3 {
 			continue
 		}
-		if strings.HasPrefix(fields[0], "@") || strings.HasPrefix(fields[0], "|") {
+		if strings.HasPrefix(fields[0], "@") {
 			continue
 		}
 		if fields[1]+" "+fields[2] != keyPart {
 			continue
 		}
+		if strings.HasPrefix(fields[0], "|") {
+			for _, addr := range addrs {
+				if hashedHostMatches(fields[0], addr) {
+					return true
+				}
+			}
+			continue
+		}
 		for _, existingHost := range strings.Split(fields[0], ",") {
 			if addrSet[existingHost] {
 				return true
@@ -228,34 +365,43 @@ func (c *Client) appendHostKey(f *os.File, host string, remote net.Addr, pubKey
 		return nil
 	}
 
-	if _, err := f.Seek(0, io.SeekStart); err != nil {
+	existing, err := readKnownHostsFile(f)
+	if err != nil {
 		return err
 	}
-	existing, err := io.ReadAll(f)
-	if err != nil {
+	if err := c.checkRevokedHostKey(host, pubKey, parseKnownHostLines(existing)); err != nil {
 		return err
 	}
-	if hostKeyAlreadyStored(string(existing), addrs, pubKey) {
+	if hostKeyAlreadyStored(existing, addrs, pubKey) {
 		return nil
 	}
 
-	line := knownhosts.Line(addrs, pubKey)
 	if _, err := f.Seek(0, io.SeekEnd); err != nil {
 		return err
 	}
-	_, fileErr := f.WriteString(fmt.Sprintf("%v\n", line))
+	_, fileErr := f.WriteString(knownHostsEntry(addrs, pubKey, c.hashKnownHosts))
+	invalidateKnownHostsSnapshot(f.Name())
 	return fileErr
 }
 
+// knownHostsEntry renders the lines appended for a new host key. With
+// hashing enabled every address gets its own |1|salt|hash line, the same
+// way ssh-keygen -H rewrites a file.
+func knownHostsEntry(addrs []string, pubKey ssh.PublicKey, hash bool) string {
+	if !hash {
+		return fmt.Sprintf("%v\n", knownhosts.Line(addrs, pubKey))
+	}
+	entry := ""
+	for _, addr := range addrs {
+		entry += fmt.Sprintf("%s %s\n", knownhosts.HashHostname(addr), serializedHostKey(pubKey))
+	}
+	return entry
+}
+
 func (c *Client) getSSHFolderPath() string {
 	sshFolderPath := c.sshFolderPath
 	if sshFolderPath == "" {
-		sshFolderPath = SSHFolderPathPackage
-		if sshFolderPath == "" {
-			homeDir := os.Getenv("HOME")
-			sshFolderPath = filepath.Join(homeDir, ".ssh")
-		}
-
+		sshFolderPath = filepath.Join(os.Getenv("HOME"), ".ssh")
 	}
 	return sshFolderPath
 }
@@ -0,0 +1,138 @@
+package sshclient
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// knownHostsSnapshots caches the parsed content of every known_hosts file
+// by path. Readers never take knownHostsMu; a snapshot is reused as long as
+// the file's modification time and size are unchanged.
+var knownHostsSnapshots sync.Map
+
+type knownHostsSnapshot struct {
+	path    string
+	modTime time.Time
+	size    int64
+	lines   []knownHostLine
+}
+
+func loadKnownHostsSnapshot(path string) (*knownHostsSnapshot, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &knownHostsSnapshot{path: path}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cached, ok := knownHostsSnapshots.Load(path); ok {
+		snapshot := cached.(*knownHostsSnapshot)
+		if snapshot.modTime.Equal(info.ModTime()) && snapshot.size == info.Size() {
+			return snapshot, nil
+		}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := newKnownHostsSnapshot(path, string(content))
+	snapshot.modTime = info.ModTime()
+	snapshot.size = info.Size()
+	knownHostsSnapshots.Store(path, snapshot)
+	return snapshot, nil
+}
+
+func newKnownHostsSnapshot(path, content string) *knownHostsSnapshot {
+	return &knownHostsSnapshot{
+		path:  path,
+		lines: parseKnownHostLines(content),
+	}
+}
+
+func invalidateKnownHostsSnapshot(path string) {
+	knownHostsSnapshots.Delete(path)
+}
+
+func (s *knownHostsSnapshot) callback() ssh.HostKeyCallback {
+	checker := &ssh.CertChecker{
+		IsHostAuthority: s.isHostAuthority,
+		IsRevoked:       s.isRevoked,
+		HostKeyFallback: s.check,
+	}
+	return checker.CheckHostKey
+}
+
+func (s *knownHostsSnapshot) knownKey(line knownHostLine) knownhosts.KnownKey {
+	filename := line.filename
+	if filename == "" {
+		filename = s.path
+	}
+	return knownhosts.KnownKey{Key: line.key, Filename: filename, Line: line.lineNum}
+}
+
+// appendMatching adds the lines of other that apply to host, remembering
+// which file each came from.
+func (s *knownHostsSnapshot) appendMatching(other *knownHostsSnapshot, host string) {
+	for _, line := range other.lines {
+		if line.marker != knownHostsMarkerRevoked && !line.matchesHost(host) {
+			continue
+		}
+		line.filename = other.knownKey(line).Filename
+		s.lines = append(s.lines, line)
+	}
+}
+
+func (s *knownHostsSnapshot) isHostAuthority(auth ssh.PublicKey, address string) bool {
+	for _, line := range s.lines {
+		if line.marker == knownHostsMarkerCert && bytes.Equal(line.key.Marshal(), auth.Marshal()) && line.matchesHost(address) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *knownHostsSnapshot) isRevoked(cert *ssh.Certificate) bool {
+	return s.revokedLine(cert) != nil || s.revokedLine(cert.SignatureKey) != nil
+}
+
+func (s *knownHostsSnapshot) revokedLine(key ssh.PublicKey) *knownHostLine {
+	for i, line := range s.lines {
+		if line.marker == knownHostsMarkerRevoked && bytes.Equal(line.key.Marshal(), key.Marshal()) {
+			return &s.lines[i]
+		}
+	}
+	return nil
+}
+
+// check mirrors the knownhosts package: the hostname is preferred over the
+// remote address and every matching line, including @cert-authority ones,
+// is reported in KeyError.Want.
+func (s *knownHostsSnapshot) check(address string, remote net.Addr, remoteKey ssh.PublicKey) error {
+	if revoked := s.revokedLine(remoteKey); revoked != nil {
+		return &knownhosts.RevokedError{Revoked: s.knownKey(*revoked)}
+	}
+	hostToCheck := address
+	if hostToCheck == "" && remote != nil {
+		hostToCheck = remote.String()
+	}
+
+	keyErr := &knownhosts.KeyError{}
+	for _, line := range s.lines {
+		if line.marker == knownHostsMarkerRevoked || !line.matchesHost(hostToCheck) {
+			continue
+		}
+		keyErr.Want = append(keyErr.Want, s.knownKey(line))
+		if bytes.Equal(line.key.Marshal(), remoteKey.Marshal()) {
+			return nil
+		}
+	}
+	return keyErr
+}
@@ -0,0 +1,291 @@
+package sshclient
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type KnownHostEntry struct {
+	Marker string
+	Hosts  []string
+	Key    ssh.PublicKey
+	Line   int
+}
+
+func (e KnownHostEntry) Fingerprint() string {
+	return ssh.FingerprintSHA256(e.Key)
+}
+
+func (e KnownHostEntry) String() string {
+	line := strings.Join(e.Hosts, ",") + " " + serializedHostKey(e.Key)
+	if e.Marker != "" {
+		line = e.Marker + " " + line
+	}
+	return line
+}
+
+func knownHostEntryFromLine(line knownHostLine) KnownHostEntry {
+	return KnownHostEntry{
+		Marker: line.marker,
+		Hosts:  strings.Split(line.patterns, ","),
+		Key:    line.key,
+		Line:   line.lineNum,
+	}
+}
+
+type KnownHosts struct {
+	path string
+
+	HashHostnames bool
+}
+
+func NewKnownHosts(path string) *KnownHosts {
+	return &KnownHosts{path: path}
+}
+
+func (c *Client) KnownHosts() *KnownHosts {
+	kh := NewKnownHosts(c.knownHostsPath())
+	kh.HashHostnames = c.hashKnownHosts
+	return kh
+}
+
+func (k *KnownHosts) Path() string {
+	return k.path
+}
+
+func (k *KnownHosts) List() ([]KnownHostEntry, error) {
+	snapshot, err := loadKnownHostsSnapshot(k.path)
+	if err != nil {
+		return nil, err
+	}
+	entries := []KnownHostEntry{}
+	for _, line := range snapshot.lines {
+		entries = append(entries, knownHostEntryFromLine(line))
+	}
+	return entries, nil
+}
+
+// Lookup returns the entries that apply to host, given in any form
+// accepted by ParseTarget, together with every @revoked entry. It reads the
+// cached snapshot of the file and never takes the file lock.
+func (k *KnownHosts) Lookup(host string) ([]KnownHostEntry, error) {
+	host = normalizeHost(host)
+	snapshot, err := loadKnownHostsSnapshot(k.path)
+	if err != nil {
+		return nil, err
+	}
+	entries := []KnownHostEntry{}
+	for _, line := range snapshot.lines {
+		if line.marker == knownHostsMarkerRevoked || line.matchesHost(host) {
+			entries = append(entries, knownHostEntryFromLine(line))
+		}
+	}
+	return entries, nil
+}
+
+// Remove deletes every key line matching host, like ssh-keygen -R.
+// @cert-authority and @revoked lines are kept.
+func (k *KnownHosts) Remove(host string) (int, error) {
+	host = normalizeHost(host)
+	removed := 0
+	err := k.withLockedFile(func(f *os.File, lines []knownHostLine) error {
+		var err error
+		removed, err = rewriteKnownHosts(f, func(line knownHostLine) bool {
+			return line.marker == "" && line.matchesHost(host)
+		}, "")
+		return err
+	})
+	return removed, err
+}
+
+func (k *KnownHosts) Replace(host string, key ssh.PublicKey) error {
+	host = normalizeHost(host)
+	return k.withLockedFile(func(f *os.File, lines []knownHostLine) error {
+		snapshot := &knownHostsSnapshot{path: k.path, lines: lines}
+		if snapshot.revokedLine(key) != nil {
+			return &HostKeyRevokedError{Host: host, Key: key, Source: k.path}
+		}
+		_, err := rewriteKnownHosts(f, func(line knownHostLine) bool {
+			return line.marker == "" && line.matchesHost(host)
+		}, knownHostsEntry([]string{host}, key, k.HashHostnames))
+		return err
+	})
+}
+
+func (k *KnownHosts) Add(hosts []string, key ssh.PublicKey) error {
+	return k.withLockedFile(func(f *os.File, lines []knownHostLine) error {
+		addrs := []string{}
+		for _, host := range hosts {
+			addrs = append(addrs, normalizeHost(host))
+		}
+		snapshot := &knownHostsSnapshot{path: k.path, lines: lines}
+		if snapshot.revokedLine(key) != nil {
+			return &HostKeyRevokedError{Host: strings.Join(addrs, ","), Key: key, Source: k.path}
+		}
+		existing, err := readKnownHostsFile(f)
+		if err != nil {
+			return err
+		}
+		if hostKeyAlreadyStored(existing, addrs, key) {
+			return nil
+		}
+		return k.appendLocked(f, addrs, key)
+	})
+}
+
+// Prune removes key lines whose named hosts are all reported unreachable.
+// Hashed and wildcard patterns cannot be resolved back to a host, so those
+// lines are kept.
+func (k *KnownHosts) Prune(unreachable func(host string) bool) (int, error) {
+	removed := 0
+	err := k.withLockedFile(func(f *os.File, lines []knownHostLine) error {
+		var err error
+		removed, err = rewriteKnownHosts(f, func(line knownHostLine) bool {
+			if line.marker != "" || strings.HasPrefix(line.patterns, "|") {
+				return false
+			}
+			for _, pattern := range strings.Split(line.patterns, ",") {
+				if pattern == "" || strings.ContainsAny(pattern, "*?!") {
+					return false
+				}
+				if !unreachable(pattern) {
+					return false
+				}
+			}
+			return true
+		}, "")
+		return err
+	})
+	return removed, err
+}
+
+func (k *KnownHosts) appendLocked(f *os.File, addrs []string, key ssh.PublicKey) error {
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	_, err := f.WriteString(knownHostsEntry(addrs, key, k.HashHostnames))
+	return err
+}
+
+func (k *KnownHosts) withLockedFile(fn func(f *os.File, lines []knownHostLine) error) error {
+	unlock, err := lockKnownHosts(k.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := os.OpenFile(k.path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	content, err := readKnownHostsFile(f)
+	if err != nil {
+		return err
+	}
+	defer invalidateKnownHostsSnapshot(k.path)
+	return fn(f, parseKnownHostLines(content))
+}
+
+func readKnownHostsFile(f *os.File) (string, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// lockKnownHosts serializes writers of path within the process and across
+// processes. The flock is held on a sibling lock file because rewrites
+// rename a new file over path, which would leave a lock on the data file
+// guarding an inode nobody reads any more.
+func lockKnownHosts(path string) (func(), error) {
+	knownHostsMu.Lock()
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		knownHostsMu.Unlock()
+		return nil, err
+	}
+	if err := lockKnownHostsFile(lock); err != nil {
+		lock.Close()
+		knownHostsMu.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlockKnownHostsFile(lock)
+		lock.Close()
+		knownHostsMu.Unlock()
+	}, nil
+}
+
+// rewriteKnownHosts drops the lines selected by remove, appends extra and
+// replaces the file with the result, keeping comments and unparsable lines
+// untouched. f must not be written after a rewrite, it still refers to the
+// replaced file.
+func rewriteKnownHosts(f *os.File, remove func(line knownHostLine) bool, extra string) (int, error) {
+	content, err := readKnownHostsFile(f)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	kept := []string{}
+	for _, raw := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(raw)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			if line, ok := parseKnownHostLine(trimmed); ok && remove(line) {
+				removed++
+				continue
+			}
+		}
+		kept = append(kept, raw)
+	}
+	if removed == 0 && extra == "" {
+		return 0, nil
+	}
+	updated := strings.Join(kept, "\n")
+	if extra != "" && updated != "" && !strings.HasSuffix(updated, "\n") {
+		updated += "\n"
+	}
+	if err := replaceKnownHostsFile(f.Name(), updated+extra); err != nil {
+		return 0, fmt.Errorf("rewrite %s: %v", f.Name(), err)
+	}
+	return removed, nil
+}
+
+// replaceKnownHostsFile writes content to a temporary file next to path and
+// renames it over path, so lock-free readers see either the old or the new
+// file and never a truncated one.
+func replaceKnownHostsFile(path, content string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".known_hosts-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if info, err := os.Stat(path); err == nil {
+		if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,181 @@
+package sshclient
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newTestKnownHosts(t *testing.T, content string) *KnownHosts {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return NewKnownHosts(path)
+}
+
+func readTestKnownHosts(t *testing.T, kh *KnownHosts) string {
+	t.Helper()
+	b, err := os.ReadFile(kh.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestKnownHostsListAndLookup(t *testing.T) {
+	web := testEd25519Key(t)
+	db := testECDSAKey(t)
+	ca := testSigner(t)
+	kh := newTestKnownHosts(t, "# comment\n"+
+		knownhosts.Line([]string{"web.example", "10.0.0.1"}, web)+"\n"+
+		knownhosts.Line([]string{"db.example:2222"}, db)+"\n"+
+		"@cert-authority *.example "+serializedHostKey(ca.PublicKey())+"\n")
+
+	entries, err := kh.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].Line != 2 || entries[2].Marker != "@cert-authority" {
+		t.Fatalf("got %v", entries)
+	}
+
+	found, err := kh.Lookup("db.example:2222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Fingerprint() != entries[1].Fingerprint() {
+		t.Fatalf("got %v", found)
+	}
+	if found, _ := kh.Lookup("db.example"); len(found) != 1 || found[0].Marker != "@cert-authority" {
+		t.Fatalf("expected only the authority on the default port, got %v", found)
+	}
+}
+
+func TestKnownHostsRemoveKeepsMarkersAndComments(t *testing.T) {
+	key := testEd25519Key(t)
+	other := testEd25519Key(t)
+	kh := newTestKnownHosts(t, "# comment\n"+
+		knownhosts.Line([]string{"web.example", "10.0.0.1"}, key)+"\n"+
+		knownhosts.HashHostname("web.example")+" "+serializedHostKey(other)+"\n"+
+		knownhosts.Line([]string{"db.example"}, other)+"\n"+
+		"@revoked * "+serializedHostKey(testEd25519Key(t))+"\n")
+
+	removed, err := kh.Remove("web.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Fatalf("expected 2 removed lines, got %d", removed)
+	}
+	content := readTestKnownHosts(t, kh)
+	if !strings.Contains(content, "# comment") || !strings.Contains(content, "db.example") || !strings.Contains(content, "@revoked") {
+		t.Fatalf("unexpected content %q", content)
+	}
+	if strings.Contains(content, "web.example") {
+		t.Fatalf("web.example still present: %q", content)
+	}
+}
+
+func TestKnownHostsReplace(t *testing.T) {
+	oldKey := testECDSAKey(t)
+	newKey := testECDSAKey(t)
+	kh := newTestKnownHosts(t, knownhosts.Line([]string{"web.example"}, oldKey)+"\n")
+	if err := kh.Replace("web.example:22", newKey); err != nil {
+		t.Fatal(err)
+	}
+	found, err := kh.Lookup("web.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Fingerprint() != knownHostEntryFromLine(knownHostLine{key: newKey}).Fingerprint() {
+		t.Fatalf("got %v", found)
+	}
+}
+
+func TestKnownHostsPrune(t *testing.T) {
+	kh := newTestKnownHosts(t,
+		knownhosts.Line([]string{"gone.example"}, testEd25519Key(t))+"\n"+
+			knownhosts.Line([]string{"gone.example", "alive.example"}, testEd25519Key(t))+"\n"+
+			"*.example "+serializedHostKey(testEd25519Key(t))+"\n"+
+			knownhosts.HashHostname("gone.example")+" "+serializedHostKey(testEd25519Key(t))+"\n")
+	removed, err := kh.Prune(func(host string) bool {
+		return host == "gone.example"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Fatalf("expected 1 removed line, got %d", removed)
+	}
+	if countHostKeyLines(readTestKnownHosts(t, kh)) != 3 {
+		t.Fatalf("unexpected content %q", readTestKnownHosts(t, kh))
+	}
+}
+
+func TestKnownHostsConcurrentAddDoesNotDuplicate(t *testing.T) {
+	kh := newTestKnownHosts(t, "")
+	key := testEd25519Key(t)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := kh.Add([]string{"web.example"}, key); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if countHostKeyLines(readTestKnownHosts(t, kh)) != 1 {
+		t.Fatalf("unexpected content %q", readTestKnownHosts(t, kh))
+	}
+}
+
+func TestKnownHostsRewriteReplacesFileAtomically(t *testing.T) {
+	key := testEd25519Key(t)
+	original := knownhosts.Line([]string{"web.example"}, key) + "\n" +
+		knownhosts.Line([]string{"db.example"}, key) + "\n"
+	kh := newTestKnownHosts(t, original)
+	if err := os.Chmod(kh.Path(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := os.Open(kh.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	if removed, err := kh.Remove("web.example"); err != nil || removed != 1 {
+		t.Fatalf("got %d, %v", removed, err)
+	}
+	// A reader that opened the file before the rewrite keeps seeing the
+	// complete old content instead of a truncated file.
+	content, err := readKnownHostsFile(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content != original {
+		t.Fatalf("reader saw %q", content)
+	}
+	if strings.Contains(readTestKnownHosts(t, kh), "web.example") {
+		t.Fatalf("web.example still present: %q", readTestKnownHosts(t, kh))
+	}
+	if info, err := os.Stat(kh.Path()); err != nil || info.Mode().Perm() != 0644 {
+		t.Fatalf("expected mode 0644 to be kept, got %v, %v", info.Mode(), err)
+	}
+	files, err := os.ReadDir(filepath.Dir(kh.Path()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if file.Name() != "known_hosts" && file.Name() != "known_hosts.lock" {
+			t.Fatalf("unexpected leftover %s", file.Name())
+		}
+	}
+}
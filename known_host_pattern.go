@@ -0,0 +1,170 @@
+package sshclient
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	knownHostsMarkerCert    = "@cert-authority"
+	knownHostsMarkerRevoked = "@revoked"
+)
+
+type knownHostLine struct {
+	marker       string
+	patterns     string
+	hostPatterns []knownHostPattern
+	key          ssh.PublicKey
+	raw          string
+	filename     string
+	lineNum      int
+}
+
+type knownHostPattern struct {
+	host, port string
+	negate     bool
+}
+
+func parseKnownHostPatterns(patterns string) []knownHostPattern {
+	parsed := []knownHostPattern{}
+	for _, pattern := range strings.Split(patterns, ",") {
+		if pattern == "" {
+			continue
+		}
+		negate := strings.HasPrefix(pattern, "!")
+		if negate {
+			pattern = pattern[1:]
+		}
+		host, port := splitKnownHostsAddr(pattern)
+		parsed = append(parsed, knownHostPattern{host: host, port: port, negate: negate})
+	}
+	return parsed
+}
+
+func parseKnownHostLines(content string) []knownHostLine {
+	lines := []knownHostLine{}
+	for index, raw := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(raw)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		line, ok := parseKnownHostLine(trimmed)
+		if !ok {
+			continue
+		}
+		line.raw = trimmed
+		line.lineNum = index + 1
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func parseKnownHostLine(line string) (knownHostLine, bool) {
+	fields := strings.Fields(line)
+	result := knownHostLine{}
+	if len(fields) > 0 && (fields[0] == knownHostsMarkerCert || fields[0] == knownHostsMarkerRevoked) {
+		result.marker = fields[0]
+		fields = fields[1:]
+	}
+	if len(fields) < 3 {
+		return result, false
+	}
+	keyBytes, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return result, false
+	}
+	key, err := ssh.ParsePublicKey(keyBytes)
+	if err != nil {
+		return result, false
+	}
+	result.patterns = fields[0]
+	if !strings.HasPrefix(result.patterns, "|") {
+		result.hostPatterns = parseKnownHostPatterns(result.patterns)
+	}
+	result.key = key
+	return result, true
+}
+
+// matchesHost reports whether the host patterns of the line match
+// hostWithPort, following the sshd(8) rules for negation, wildcards,
+// bracketed ports and hashed entries.
+func (l knownHostLine) matchesHost(hostWithPort string) bool {
+	if strings.HasPrefix(l.patterns, "|") {
+		return hashedHostMatches(l.patterns, normalizeHost(hostWithPort))
+	}
+	patterns := l.hostPatterns
+	if patterns == nil {
+		patterns = parseKnownHostPatterns(l.patterns)
+	}
+	host, port := splitKnownHostsAddr(hostWithPort)
+	matched := false
+	for _, pattern := range patterns {
+		if pattern.port != port || !wildcardMatch(pattern.host, host) {
+			continue
+		}
+		if pattern.negate {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+func splitKnownHostsAddr(addr string) (string, string) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+		port = "22"
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return host, port
+}
+
+func wildcardMatch(pattern, str string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := 0; i <= len(str); i++ {
+				if wildcardMatch(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		str = str[1:]
+	}
+	return len(str) == 0
+}
+
+func hashedHostMatches(encoded, normalizedHost string) bool {
+	parts := strings.Split(encoded, "|")
+	if len(parts) != 4 || parts[1] != "1" {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	hash, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(normalizedHost))
+	return bytes.Equal(mac.Sum(nil), hash)
+}
@@ -0,0 +1,82 @@
+package sshclient
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var krlMagic = []byte("SSHKRL\n\x00")
+
+type HostKeyRevokedError struct {
+	Host   string
+	Key    ssh.PublicKey
+	Source string
+}
+
+func (e *HostKeyRevokedError) Error() string {
+	return fmt.Sprintf("host key %s of %s is revoked by %s", ssh.FingerprintSHA256(e.Key), e.Host, e.Source)
+}
+
+func loadRevokedHostKeys(path string) ([]ssh.PublicKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(content, krlMagic) {
+		return nil, fmt.Errorf("revoked host keys %s: binary KRL files are not supported", path)
+	}
+	keys := []ssh.PublicKey{}
+	rest := content
+	for len(bytes.TrimSpace(rest)) > 0 {
+		key, _, _, next, err := ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			return nil, fmt.Errorf("revoked host keys %s: %v", path, err)
+		}
+		keys = append(keys, key)
+		rest = next
+	}
+	return keys, nil
+}
+
+func revokedCandidates(pubKey ssh.PublicKey) []ssh.PublicKey {
+	if cert, ok := pubKey.(*ssh.Certificate); ok {
+		return []ssh.PublicKey{cert, cert.Key, cert.SignatureKey}
+	}
+	return []ssh.PublicKey{pubKey}
+}
+
+// checkRevokedHostKey refuses keys listed under a @revoked marker in
+// known_hosts or in the RevokedHostKeys file. For certificates both the
+// certified key and the signing authority are checked.
+func (c *Client) checkRevokedHostKey(host string, pubKey ssh.PublicKey, lines []knownHostLine) error {
+	candidates := revokedCandidates(pubKey)
+	for _, line := range lines {
+		if line.marker != knownHostsMarkerRevoked {
+			continue
+		}
+		for _, candidate := range candidates {
+			if bytes.Equal(line.key.Marshal(), candidate.Marshal()) {
+				return &HostKeyRevokedError{Host: host, Key: candidate, Source: "known_hosts"}
+			}
+		}
+	}
+
+	if c.revokedHostKeysPath == "" {
+		return nil
+	}
+	revoked, err := loadRevokedHostKeys(c.revokedHostKeysPath)
+	if err != nil {
+		return err
+	}
+	for _, key := range revoked {
+		for _, candidate := range candidates {
+			if bytes.Equal(key.Marshal(), candidate.Marshal()) {
+				return &HostKeyRevokedError{Host: host, Key: candidate, Source: c.revokedHostKeysPath}
+			}
+		}
+	}
+	return nil
+}
@@ -21,9 +21,10 @@ import (
 func newTestClient(t *testing.T) *Client {
 	t.Helper()
 	return &Client{
-		sshFolderPath: t.TempDir(),
-		host:          "127.0.0.1",
-		port:          "22",
+		sshFolderPath:         t.TempDir(),
+		host:                  "127.0.0.1",
+		port:                  "22",
+		globalKnownHostsFiles: []string{},
 	}
 }
 
@@ -177,7 +178,9 @@ func TestHostKeyAlgorithmsPrefersKnownECDSA(t *testing.T) {
 
 func TestHostKeyAlgorithmsUnknownHostIsNil(t *testing.T) {
 	c := newTestClient(t)
-	c.createKnownHosts()
+	if err := c.createKnownHosts(); err != nil {
+		t.Fatal(err)
+	}
 	if algos := c.hostKeyAlgorithms("127.0.0.1:22"); algos != nil {
 		t.Fatalf("expected nil, got %v", algos)
 	}
@@ -222,3 +225,520 @@ func TestHostKeyCallbackConcurrentWritesDoNotDuplicate(t *testing.T) {
 		t.Fatalf("expected 1 line, got %q", content)
 	}
 }
+
+func testSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func testHostCert(t *testing.T, ca ssh.Signer, principals []string, validAfter, validBefore uint64) *ssh.Certificate {
+	t.Helper()
+	cert := &ssh.Certificate{
+		Key:             testEd25519Key(t),
+		CertType:        ssh.HostCert,
+		ValidPrincipals: principals,
+		ValidAfter:      validAfter,
+		ValidBefore:     validBefore,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func writeKnownHosts(t *testing.T, c *Client, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(c.getSSHFolderPath(), "known_hosts"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHostKeyCallbackAcceptsCertFromAuthorityWithoutAppending(t *testing.T) {
+	c := newTestClient(t)
+	ca := testSigner(t)
+	writeKnownHosts(t, c, "@cert-authority 127.0.0.1 "+serializedHostKey(ca.PublicKey())+"\n")
+	cert := testHostCert(t, ca, []string{"127.0.0.1"}, 0, ssh.CertTimeInfinity)
+	if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), cert); err != nil {
+		t.Fatal(err)
+	}
+	if countHostKeyLines(knownHostsContent(t, c)) != 1 {
+		t.Fatal("accepted certificate should not be appended")
+	}
+}
+
+func TestHostKeyCallbackRejectsCertWithWrongPrincipal(t *testing.T) {
+	c := newTestClient(t)
+	ca := testSigner(t)
+	writeKnownHosts(t, c, "@cert-authority 127.0.0.1 "+serializedHostKey(ca.PublicKey())+"\n")
+	cert := testHostCert(t, ca, []string{"other.example"}, 0, ssh.CertTimeInfinity)
+	if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), cert); err == nil {
+		t.Fatal("expected principal mismatch")
+	}
+	if countHostKeyLines(knownHostsContent(t, c)) != 1 {
+		t.Fatal("rejected certificate should not be appended")
+	}
+}
+
+func TestHostKeyCallbackRejectsExpiredCert(t *testing.T) {
+	c := newTestClient(t)
+	ca := testSigner(t)
+	writeKnownHosts(t, c, "@cert-authority 127.0.0.1 "+serializedHostKey(ca.PublicKey())+"\n")
+	cert := testHostCert(t, ca, []string{"127.0.0.1"}, 0, 1)
+	if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), cert); err == nil {
+		t.Fatal("expected expired certificate to be rejected")
+	}
+}
+
+func TestHostKeyCallbackRejectsCertFromUnknownAuthority(t *testing.T) {
+	c := newTestClient(t)
+	cert := testHostCert(t, testSigner(t), []string{"127.0.0.1"}, 0, ssh.CertTimeInfinity)
+	if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), cert); err == nil {
+		t.Fatal("expected certificate without authority to be rejected")
+	}
+	if countHostKeyLines(knownHostsContent(t, c)) != 0 {
+		t.Fatal("certificate without authority should not be appended")
+	}
+}
+
+func TestHostKeyAlgorithmsPrefersCertsWhenAuthorityMatches(t *testing.T) {
+	c := newTestClient(t)
+	ca := testSigner(t)
+	key := testECDSAKey(t)
+	writeKnownHosts(t, c, "@cert-authority *.example "+serializedHostKey(ca.PublicKey())+"\n"+
+		knownhosts.Line([]string{"web.example"}, key)+"\n")
+	algos := c.hostKeyAlgorithms("web.example:22")
+	if len(algos) != len(hostCertAlgorithms)+1 || algos[0] != ssh.CertAlgoED25519v01 || algos[len(algos)-1] != ssh.KeyAlgoECDSA256 {
+		t.Fatalf("got %v", algos)
+	}
+	if algos := c.hostKeyAlgorithms("127.0.0.1:22"); algos != nil {
+		t.Fatalf("expected nil for host outside authority, got %v", algos)
+	}
+}
+
+func TestKnownHostLineMatchesPatterns(t *testing.T) {
+	key := testEd25519Key(t)
+	cases := []struct {
+		patterns string
+		host     string
+		want     bool
+	}{
+		{"*.example", "web.example:22", true},
+		{"*.example,!db.example", "db.example:22", false},
+		{"[web.example]:2222", "web.example:2222", true},
+		{"web.example", "web.example:2222", false},
+		{"web?.example", "web1.example:22", true},
+		{knownhosts.HashHostname("web.example"), "web.example:22", true},
+		{knownhosts.HashHostname("web.example"), "db.example:22", false},
+	}
+	for _, tc := range cases {
+		line := knownHostLine{patterns: tc.patterns, key: key}
+		if got := line.matchesHost(tc.host); got != tc.want {
+			t.Errorf("%s vs %s: got %v, want %v", tc.patterns, tc.host, got, tc.want)
+		}
+	}
+}
+
+func TestHostKeyCallbackRefusesKeyRevokedInKnownHosts(t *testing.T) {
+	c := newTestClient(t)
+	key := testECDSAKey(t)
+	writeKnownHosts(t, c, "@revoked * "+serializedHostKey(key)+"\n")
+	err := c.hostKeyCallback("127.0.0.1:22", testRemote(), key)
+	var revokedErr *HostKeyRevokedError
+	if !errors.As(err, &revokedErr) {
+		t.Fatalf("expected revoked error, got %v", err)
+	}
+	if countHostKeyLines(knownHostsContent(t, c)) != 1 {
+		t.Fatal("revoked key should not be appended")
+	}
+}
+
+func TestHostKeyCallbackRefusesKeyFromRevokedHostKeysFile(t *testing.T) {
+	c := newTestClient(t)
+	key := testEd25519Key(t)
+	c.revokedHostKeysPath = filepath.Join(t.TempDir(), "revoked_keys")
+	if err := os.WriteFile(c.revokedHostKeysPath, ssh.MarshalAuthorizedKey(key), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err := c.hostKeyCallback("127.0.0.1:22", testRemote(), key)
+	var revokedErr *HostKeyRevokedError
+	if !errors.As(err, &revokedErr) || revokedErr.Source != c.revokedHostKeysPath {
+		t.Fatalf("expected revoked error, got %v", err)
+	}
+	if countHostKeyLines(knownHostsContent(t, c)) != 0 {
+		t.Fatal("revoked key should not be appended")
+	}
+	if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), testEd25519Key(t)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHostKeyCallbackRefusesCertFromRevokedAuthority(t *testing.T) {
+	c := newTestClient(t)
+	ca := testSigner(t)
+	writeKnownHosts(t, c, "@cert-authority 127.0.0.1 "+serializedHostKey(ca.PublicKey())+"\n"+
+		"@revoked * "+serializedHostKey(ca.PublicKey())+"\n")
+	cert := testHostCert(t, ca, []string{"127.0.0.1"}, 0, ssh.CertTimeInfinity)
+	err := c.hostKeyCallback("127.0.0.1:22", testRemote(), cert)
+	var revokedErr *HostKeyRevokedError
+	if !errors.As(err, &revokedErr) {
+		t.Fatalf("expected revoked error, got %v", err)
+	}
+}
+
+func TestHostKeyCallbackFailsClosedOnMissingRevokedHostKeysFile(t *testing.T) {
+	c := newTestClient(t)
+	c.revokedHostKeysPath = filepath.Join(t.TempDir(), "missing")
+	if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), testEd25519Key(t)); err == nil {
+		t.Fatal("expected error for unreadable revoked host keys file")
+	}
+}
+
+func TestAppendHostKeyNeverAddsRevokedKey(t *testing.T) {
+	c := newTestClient(t)
+	key := testECDSAKey(t)
+	writeKnownHosts(t, c, "@revoked * "+serializedHostKey(key)+"\n")
+	f, err := os.OpenFile(filepath.Join(c.getSSHFolderPath(), "known_hosts"), os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	err = c.appendHostKey(f, "127.0.0.1:22", testRemote(), key)
+	var revokedErr *HostKeyRevokedError
+	if !errors.As(err, &revokedErr) {
+		t.Fatalf("expected revoked error, got %v", err)
+	}
+}
+
+func TestHostKeyCallbackWritesHashedEntriesOnce(t *testing.T) {
+	c := newTestClient(t)
+	c.hashKnownHosts = true
+	key := testECDSAKey(t)
+	remote := &net.TCPAddr{IP: net.ParseIP("141.230.0.70"), Port: 22}
+	if err := c.hostKeyCallback("staging.example:22", remote, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.hostKeyCallback("staging.example:22", remote, key); err != nil {
+		t.Fatal(err)
+	}
+	content := knownHostsContent(t, c)
+	if strings.Contains(content, "staging.example") || strings.Contains(content, "141.230.0.70") {
+		t.Fatalf("hostnames leaked: %q", content)
+	}
+	if countHostKeyLines(content) != 2 {
+		t.Fatalf("expected one hashed line per address, got %q", content)
+	}
+	if err := c.hostKeyCallback("staging.example:22", remote, testECDSAKey(t)); err == nil {
+		t.Fatal("expected mismatch against hashed entry")
+	}
+}
+
+func TestHostKeyAlreadyStoredMatchesHashedLine(t *testing.T) {
+	key := testEd25519Key(t)
+	existing := knownhosts.HashHostname("[web.example]:2222") + " " + serializedHostKey(key) + "\n"
+	if !hostKeyAlreadyStored(existing, []string{"[web.example]:2222"}, key) {
+		t.Fatal("expected hashed line to be detected")
+	}
+	if hostKeyAlreadyStored(existing, []string{"web.example"}, key) {
+		t.Fatal("hashed line should not cover a different port")
+	}
+}
+
+func TestHostKeyCallbackStrictRejectsUnknownHost(t *testing.T) {
+	c := newTestClient(t)
+	c.hostKeyPolicy = HostKeyPolicyStrict
+	key := testECDSAKey(t)
+	err := c.hostKeyCallback("127.0.0.1:22", testRemote(), key)
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) || len(keyErr.Want) != 0 {
+		t.Fatalf("expected unknown host error, got %v", err)
+	}
+	if countHostKeyLines(knownHostsContent(t, c)) != 0 {
+		t.Fatal("strict policy should not append")
+	}
+	writeKnownHosts(t, c, knownhosts.Line([]string{"127.0.0.1"}, key)+"\n")
+	if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHostKeyCallbackPinnedPolicy(t *testing.T) {
+	c := newTestClient(t)
+	key := testEd25519Key(t)
+	c.hostKeyPolicy = HostKeyPolicyPinned
+	c.pinnedFingerprints = []string{ssh.FingerprintSHA256(key)}
+	if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), key); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), testEd25519Key(t)); err == nil {
+		t.Fatal("expected unpinned key to be rejected")
+	}
+	if _, err := os.Stat(filepath.Join(c.getSSHFolderPath(), "known_hosts")); !os.IsNotExist(err) {
+		t.Fatal("pinned policy should not touch known_hosts")
+	}
+	if algos := c.hostKeyAlgorithms("127.0.0.1:22"); algos != nil {
+		t.Fatalf("expected nil, got %v", algos)
+	}
+}
+
+func TestHostKeyCallbackInsecureAcceptsAnyKey(t *testing.T) {
+	c := newTestClient(t)
+	c.hostKeyPolicy = HostKeyPolicyInsecure
+	if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), testEd25519Key(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(c.getSSHFolderPath(), "known_hosts")); !os.IsNotExist(err) {
+		t.Fatal("insecure policy should not touch known_hosts")
+	}
+}
+
+func TestHostKeyCallbackPerHostPinsBypassKnownHosts(t *testing.T) {
+	c := newTestClient(t)
+	key := testEd25519Key(t)
+	c.pinnedHostKeys = map[string][]string{
+		"127.0.0.1":        {ssh.FingerprintSHA256(key)},
+		"other.example:22": {"SHA256:unused"},
+	}
+	if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(c.getSSHFolderPath(), "known_hosts")); !os.IsNotExist(err) {
+		t.Fatal("pinned host should not touch known_hosts")
+	}
+
+	wrong := testEd25519Key(t)
+	err := c.hostKeyCallback("127.0.0.1:22", testRemote(), wrong)
+	var mismatch *HostKeyMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected mismatch error, got %v", err)
+	}
+	if mismatch.Actual != ssh.FingerprintSHA256(wrong) || len(mismatch.Expected) != 1 || mismatch.Expected[0] != ssh.FingerprintSHA256(key) {
+		t.Fatalf("unexpected mismatch %+v", mismatch)
+	}
+	if !strings.Contains(err.Error(), mismatch.Actual) || !strings.Contains(err.Error(), mismatch.Expected[0]) {
+		t.Fatalf("error should name both fingerprints: %v", err)
+	}
+}
+
+func TestHostKeyCallbackInsecureStillChecksPins(t *testing.T) {
+	c := newTestClient(t)
+	c.hostKeyPolicy = HostKeyPolicyInsecure
+	key := testEd25519Key(t)
+	c.pinnedHostKeys = map[string][]string{"127.0.0.1": {ssh.FingerprintSHA256(key)}}
+	if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), key); err != nil {
+		t.Fatal(err)
+	}
+	var mismatch *HostKeyMismatchError
+	if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), testEd25519Key(t)); !errors.As(err, &mismatch) {
+		t.Fatalf("expected a pin mismatch, got %v", err)
+	}
+	if err := c.hostKeyCallback("127.0.0.2:22", testRemote(), testEd25519Key(t)); err != nil {
+		t.Fatalf("unpinned hosts stay insecure, got %v", err)
+	}
+}
+
+func TestHostKeyCallbackPinsOfOtherHostsDoNotApply(t *testing.T) {
+	c := newTestClient(t)
+	c.pinnedHostKeys = map[string][]string{"[127.0.0.1]:2222": {"SHA256:unused"}}
+	if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), testEd25519Key(t)); err != nil {
+		t.Fatal(err)
+	}
+	if countHostKeyLines(knownHostsContent(t, c)) != 1 {
+		t.Fatal("unpinned host should go through known_hosts")
+	}
+}
+
+func silenceStdout(b *testing.B) {
+	b.Helper()
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	b.Cleanup(func() {
+		os.Stdout = stdout
+		devNull.Close()
+	})
+}
+
+func benchmarkKnownHosts(b *testing.B) (*Client, ssh.PublicKey) {
+	b.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		b.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		b.Fatal(err)
+	}
+	c := &Client{sshFolderPath: b.TempDir(), host: "127.0.0.1", port: "22", globalKnownHostsFiles: []string{}}
+	var content strings.Builder
+	for i := 0; i < 5000; i++ {
+		content.WriteString(knownhosts.Line([]string{RandSeq(12) + ".example"}, key) + "\n")
+	}
+	content.WriteString(knownhosts.Line([]string{"127.0.0.1"}, key) + "\n")
+	if err := os.WriteFile(c.knownHostsPath(), []byte(content.String()), 0600); err != nil {
+		b.Fatal(err)
+	}
+	silenceStdout(b)
+	return c, key
+}
+
+func BenchmarkHostKeyVerifyParallelCached(b *testing.B) {
+	c, key := benchmarkKnownHosts(b)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.hostKeyAlgorithms("127.0.0.1:22")
+			if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), key); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkHostKeyVerifyParallelUncached reproduces the previous approach
+// of parsing known_hosts twice per connect under the global mutex.
+func BenchmarkHostKeyVerifyParallelUncached(b *testing.B) {
+	c, key := benchmarkKnownHosts(b)
+	verify := func() error {
+		knownHostsMu.Lock()
+		defer knownHostsMu.Unlock()
+		kh, err := knownhosts.New(c.knownHostsPath())
+		if err != nil {
+			return err
+		}
+		return kh("127.0.0.1:22", testRemote(), key)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			verify()
+			if err := verify(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestHostKeyCallbackConsultsGlobalKnownHostsFiles(t *testing.T) {
+	c := newTestClient(t)
+	globalKey := testECDSAKey(t)
+	global := filepath.Join(t.TempDir(), "ssh_known_hosts")
+	if err := os.WriteFile(global, []byte(knownhosts.Line([]string{"127.0.0.1"}, globalKey)+"\n"), 0400); err != nil {
+		t.Fatal(err)
+	}
+	c.globalKnownHostsFiles = []string{filepath.Join(t.TempDir(), "missing"), global}
+	c.userKnownHostsFile = filepath.Join(t.TempDir(), "user_known_hosts")
+
+	if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), globalKey); err != nil {
+		t.Fatal(err)
+	}
+	if algos := c.hostKeyAlgorithms("127.0.0.1:22"); len(algos) != 1 || algos[0] != ssh.KeyAlgoECDSA256 {
+		t.Fatalf("got %v", algos)
+	}
+	err := c.hostKeyCallback("127.0.0.1:22", testRemote(), testECDSAKey(t))
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) || len(keyErr.Want) != 1 || keyErr.Want[0].Filename != global {
+		t.Fatalf("expected mismatch against global file, got %v", err)
+	}
+
+	other := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 22}
+	if err := c.hostKeyCallback("10.0.0.2:22", other, testEd25519Key(t)); err != nil {
+		t.Fatal(err)
+	}
+	userContent, err := os.ReadFile(c.userKnownHostsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if countHostKeyLines(string(userContent)) != 1 {
+		t.Fatalf("expected new host in user file, got %q", userContent)
+	}
+	if _, err := os.Stat(filepath.Join(c.getSSHFolderPath(), "known_hosts")); !os.IsNotExist(err) {
+		t.Fatal("default known_hosts should not be used when a user file is configured")
+	}
+}
+
+func TestHostKeyApproverUnknownHost(t *testing.T) {
+	c := newTestClient(t)
+	key := testECDSAKey(t)
+	var prompts []HostKeyPrompt
+	decision := HostKeyReject
+	c.hostKeyApprover = func(prompt HostKeyPrompt) (HostKeyDecision, error) {
+		prompts = append(prompts, prompt)
+		return decision, nil
+	}
+
+	if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), key); err == nil {
+		t.Fatal("expected rejection")
+	}
+	decision = HostKeyAcceptOnce
+	if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), key); err != nil {
+		t.Fatal(err)
+	}
+	if countHostKeyLines(knownHostsContent(t, c)) != 0 {
+		t.Fatal("accept once should not persist")
+	}
+	decision = HostKeyAcceptAndPersist
+	if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), key); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), key); err != nil {
+		t.Fatal(err)
+	}
+	if len(prompts) != 3 {
+		t.Fatalf("known key should not prompt, got %d prompts", len(prompts))
+	}
+	if prompts[0].Host != "127.0.0.1:22" || prompts[0].Fingerprint != ssh.FingerprintSHA256(key) || prompts[0].Changed() {
+		t.Fatalf("unexpected prompt %+v", prompts[0])
+	}
+	if countHostKeyLines(knownHostsContent(t, c)) != 1 {
+		t.Fatal("accept and persist should write the key")
+	}
+}
+
+func TestHostKeyApproverChangedHost(t *testing.T) {
+	c := newTestClient(t)
+	oldKey := testECDSAKey(t)
+	newKey := testECDSAKey(t)
+	writeKnownHosts(t, c, knownhosts.Line([]string{"127.0.0.1"}, oldKey)+"\n")
+	var prompt HostKeyPrompt
+	c.hostKeyApprover = func(p HostKeyPrompt) (HostKeyDecision, error) {
+		prompt = p
+		return HostKeyAcceptAndPersist, nil
+	}
+	if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), newKey); err != nil {
+		t.Fatal(err)
+	}
+	if !prompt.Changed() || ssh.FingerprintSHA256(prompt.KnownKeys[0]) != ssh.FingerprintSHA256(oldKey) {
+		t.Fatalf("unexpected prompt %+v", prompt)
+	}
+	entries, err := c.KnownHosts().Lookup("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Fingerprint() != ssh.FingerprintSHA256(newKey) {
+		t.Fatalf("expected key to be replaced, got %v", entries)
+	}
+}
+
+func TestHostKeyApproverErrorRejects(t *testing.T) {
+	c := newTestClient(t)
+	c.hostKeyApprover = func(p HostKeyPrompt) (HostKeyDecision, error) {
+		return HostKeyAcceptAndPersist, errors.New("approval service unavailable")
+	}
+	if err := c.hostKeyCallback("127.0.0.1:22", testRemote(), testECDSAKey(t)); err == nil {
+		t.Fatal("expected approver error")
+	}
+	if countHostKeyLines(knownHostsContent(t, c)) != 0 {
+		t.Fatal("failed approval should not persist")
+	}
+}
@@ -26,6 +26,36 @@ func sshPrint(msg string) {
 	fmt.Println("ssh", msg, time.Now().Format(FormatTime))
 }
 
+// Logger receives the log output of one client. Clients without a logger
+// print to stdout like Log does.
+type Logger interface {
+	Printf(format string, a ...interface{})
+}
+
+func (c *Client) log(format string, a ...interface{}) {
+	if c.logger == nil {
+		Log(format, a...)
+		return
+	}
+	c.logger.Printf(format, a...)
+}
+
+func (c *Client) sshPrint(msg string) {
+	if c.logger == nil {
+		sshPrint(msg)
+		return
+	}
+	c.logger.Printf("ssh %s", msg)
+}
+
+// optionsLog returns a client holding only opts, so that code running
+// without a client logs to the Logger given with WithLogger.
+func optionsLog(opts []ClientOption) *Client {
+	probe := &Client{}
+	probe.applyOptions(opts)
+	return probe
+}
+
 func sshCaller(skip int) string {
 	pc, _, _, ok := runtime.Caller(skip)
 	if !ok {
@@ -0,0 +1,169 @@
+package sshclient
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+type ClientOption func(*Client)
+
+func (c *Client) applyOptions(opts []ClientOption) {
+	for _, opt := range opts {
+		opt(c)
+	}
+}
+
+func WithRevokedHostKeys(path string) ClientOption {
+	return func(c *Client) {
+		c.revokedHostKeysPath = path
+	}
+}
+
+func WithHashKnownHosts() ClientOption {
+	return func(c *Client) {
+		c.hashKnownHosts = true
+	}
+}
+
+func WithHostKeyPolicy(policy HostKeyPolicy) ClientOption {
+	return func(c *Client) {
+		c.hostKeyPolicy = policy
+	}
+}
+
+// WithPinnedHostKeys pins fingerprints for the host being connected to.
+// They do not apply to jump hosts, which can be pinned with
+// WithHostFingerprints.
+func WithPinnedHostKeys(fingerprints ...string) ClientOption {
+	return func(c *Client) {
+		c.pinnedFingerprints = append(c.pinnedFingerprints, fingerprints...)
+	}
+}
+
+// jumpHostOptions are the options of the target without the pins of
+// WithPinnedHostKeys, which name the target's key.
+func jumpHostOptions(opts []ClientOption) []ClientOption {
+	return append(append([]ClientOption{}, opts...), func(c *Client) {
+		c.pinnedFingerprints = nil
+	})
+}
+
+// WithHostFingerprints pins SHA256 fingerprints per host, keyed by "host"
+// or "host:port". Pinned hosts are verified without consulting known_hosts,
+// under every host key policy.
+func WithHostFingerprints(pins map[string][]string) ClientOption {
+	return func(c *Client) {
+		if c.pinnedHostKeys == nil {
+			c.pinnedHostKeys = map[string][]string{}
+		}
+		for host, fingerprints := range pins {
+			c.pinnedHostKeys[host] = append(c.pinnedHostKeys[host], fingerprints...)
+		}
+	}
+}
+
+func WithHostKeyStore(store HostKeyStore) ClientOption {
+	return func(c *Client) {
+		c.hostKeyStore = store
+	}
+}
+
+func WithKnownHostsFile(path string) ClientOption {
+	return func(c *Client) {
+		c.userKnownHostsFile = path
+	}
+}
+
+// WithGlobalKnownHostsFiles replaces the read-only files consulted before
+// the user known_hosts file. Calling it with no paths disables them.
+func WithGlobalKnownHostsFiles(paths ...string) ClientOption {
+	return func(c *Client) {
+		c.globalKnownHostsFiles = append([]string{}, paths...)
+	}
+}
+
+// WithUpdateHostKeys controls whether keys announced by the server through
+// hostkeys-00@openssh.com are proved and added to known_hosts. It is
+// enabled by default.
+func WithUpdateHostKeys(enabled bool) ClientOption {
+	return func(c *Client) {
+		c.disableHostKeyUpdates = !enabled
+	}
+}
+
+// WithHostKeyApprover lets approver decide about unknown and changed host
+// keys instead of the host key policy. The handshake timeout is suspended
+// while the host key is being approved.
+func WithHostKeyApprover(approver HostKeyApprover) ClientOption {
+	return func(c *Client) {
+		c.hostKeyApprover = approver
+	}
+}
+
+func WithPassword(password string) ClientOption {
+	return func(c *Client) {
+		c.password = password
+	}
+}
+
+func WithSSHFolderPath(sshFolderPath string) ClientOption {
+	return func(c *Client) {
+		c.sshFolderPath = sshFolderPath
+	}
+}
+
+func WithLogger(logger Logger) ClientOption {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+func WithDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) ClientOption {
+	return func(c *Client) {
+		c.dial = dial
+	}
+}
+
+func WithConnectTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.connectTimeout = timeout
+	}
+}
+
+func WithHandshakeTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.handshakeTimeout = timeout
+	}
+}
+
+func WithSessionTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.sessionTimeout = timeout
+	}
+}
+
+func WithKeepalive(interval time.Duration, maxMissed int) ClientOption {
+	return func(c *Client) {
+		c.keepaliveInterval = interval
+		c.keepaliveMaxMissed = maxMissed
+	}
+}
+
+func WithLazyConnect() ClientOption {
+	return func(c *Client) {
+		c.lazy = true
+	}
+}
+
+func WithReconnect(policy ReconnectPolicy) ClientOption {
+	return func(c *Client) {
+		c.reconnect = &policy
+	}
+}
+
+func WithMaxSessions(max int) ClientOption {
+	return func(c *Client) {
+		c.maxSessions = max
+	}
+}
@@ -0,0 +1,352 @@
+package sshclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPoolMaxIdleTime        = 5 * time.Minute
+	DefaultPoolHealthCheckTimeout = 5 * time.Second
+)
+
+var errPoolClosed = errors.New("ssh: pool is closed")
+
+type PoolConfig struct {
+	// MaxIdleTime is how long a connection nobody holds is kept open.
+	// Zero selects DefaultPoolMaxIdleTime and a negative value closes
+	// connections as soon as they are released.
+	MaxIdleTime time.Duration
+	// MaxConnsPerHost limits the connections to one host:port across all
+	// users and credentials. Get waits for one to be released when the
+	// limit is reached. Zero means no limit.
+	MaxConnsPerHost int
+	// HealthCheckTimeout bounds the keepalive sent before an idle
+	// connection is handed out again.
+	HealthCheckTimeout time.Duration
+}
+
+// Pool shares connections between callers that connect with the same user,
+// host, port, credentials and host key, algorithm and timeout settings.
+// Configs with Auth or a Dialer cannot be pooled since functions cannot be
+// compared; give NewPool WithDialer to send every connection through a
+// proxy. Connections are reference counted: every Get
+// must be matched by a Release, which replaces Exit for pooled clients.
+type Pool struct {
+	config PoolConfig
+	opts   []ClientOption
+
+	mu      sync.Mutex
+	entries map[poolKey]*poolEntry
+	changed chan struct{}
+	closed  bool
+}
+
+type poolKey struct {
+	user, addr, auth string
+}
+
+type poolEntry struct {
+	key    poolKey
+	client *Client
+	refs   int
+	ready  chan struct{}
+	idle   *time.Timer
+}
+
+// PooledClient is a shared Client obtained from a Pool. Call Release when
+// done instead of Exit, which would close it for every other holder.
+type PooledClient struct {
+	*Client
+	pool    *Pool
+	entry   *poolEntry
+	release sync.Once
+}
+
+// NewPool creates a pool; opts are applied to every connection it dials.
+func NewPool(config PoolConfig, opts ...ClientOption) *Pool {
+	return &Pool{
+		config:  config,
+		opts:    opts,
+		entries: map[poolKey]*poolEntry{},
+		changed: make(chan struct{}),
+	}
+}
+
+func newPoolKey(config ClientConfig) (poolKey, error) {
+	if config.Auth != nil {
+		return poolKey{}, errors.New("ssh: connections using ClientConfig.Auth cannot be pooled")
+	}
+	if config.Dialer != nil {
+		return poolKey{}, errors.New("ssh: connections using ClientConfig.Dialer cannot be pooled, pass WithDialer to NewPool")
+	}
+	client := newClient(config)
+	// Hash the credentials so the key does not keep the password around
+	// in a comparable form. Everything that decides how the server was
+	// verified or how the connection behaves is part of the key too.
+	h := sha256.New()
+	for _, part := range []string{
+		client.password, client.sshKeyPem, client.getSSHFolderPath(), strings.Join(client.identityFiles, "\x00"),
+		client.userKnownHostsFile, strings.Join(config.ExtraKnownHostsFiles, "\x00"), config.HostKeyPolicy.String(),
+		strings.Join(config.HostKeyAlgorithms, ","), strings.Join(config.KeyExchanges, ","),
+		strings.Join(config.Ciphers, ","), strings.Join(config.MACs, ","),
+		fmt.Sprint(config.ConnectTimeout, config.HandshakeTimeout, config.SessionTimeout),
+		fmt.Sprint(config.KeepaliveInterval, config.KeepaliveMaxMissed, config.MaxSessions, config.Reconnect != nil),
+	} {
+		fmt.Fprintf(h, "%d:%s", len(part), part)
+	}
+	if config.Reconnect != nil {
+		fmt.Fprintf(h, "reconnect:%+v", *config.Reconnect)
+	}
+	if config.Jump != nil {
+		jumpKey, err := newPoolKey(*config.Jump)
+		if err != nil {
+			return poolKey{}, err
+		}
+		fmt.Fprintf(h, "jump:%s@%s:%s", jumpKey.user, jumpKey.addr, jumpKey.auth)
+	}
+	return poolKey{user: client.username, addr: client.addr(), auth: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// Get returns a shared connection for config, dialing one if needed.
+// Connections that were idle are checked with a keepalive first.
+func (p *Pool) Get(ctx context.Context, config ClientConfig) (*PooledClient, error) {
+	key, err := newPoolKey(config)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, errPoolClosed
+		}
+		if entry := p.entries[key]; entry != nil {
+			if entry.client == nil {
+				// Another caller is dialing this key.
+				p.mu.Unlock()
+				if err := waitPool(ctx, entry.ready); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			entry.refs++
+			wasIdle := entry.refs == 1
+			if entry.idle != nil {
+				entry.idle.Stop()
+				entry.idle = nil
+			}
+			p.mu.Unlock()
+			if p.healthy(entry.client, wasIdle) {
+				return &PooledClient{Client: entry.client, pool: p, entry: entry}, nil
+			}
+			p.discard(entry)
+			continue
+		}
+		if p.config.MaxConnsPerHost > 0 && p.hostConnsLocked(key.addr) >= p.config.MaxConnsPerHost {
+			if idle := p.idleEntryLocked(key.addr); idle != nil {
+				delete(p.entries, idle.key)
+				p.mu.Unlock()
+				idle.client.closeConnection()
+				continue
+			}
+			changed := p.changed
+			p.mu.Unlock()
+			if err := waitPool(ctx, changed); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		entry := &poolEntry{key: key, refs: 1, ready: make(chan struct{})}
+		p.entries[key] = entry
+		p.mu.Unlock()
+
+		client, err := Dial(ctx, config, p.opts...)
+		p.mu.Lock()
+		close(entry.ready)
+		if err != nil {
+			delete(p.entries, key)
+			p.notifyLocked()
+			p.mu.Unlock()
+			return nil, err
+		}
+		entry.client = client
+		p.mu.Unlock()
+		return &PooledClient{Client: client, pool: p, entry: entry}, nil
+	}
+}
+
+func waitPool(ctx context.Context, ch <-chan struct{}) error {
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// healthy checks a connection before handing it out. Connections already
+// in use are only checked for having dropped; idle ones must answer a
+// keepalive.
+func (p *Pool) healthy(client *Client, wasIdle bool) bool {
+	client.connMu.Lock()
+	dropped := client.client != nil && client.connectionClosed() && client.reconnect == nil
+	client.connMu.Unlock()
+	if dropped {
+		return false
+	}
+	if !wasIdle {
+		return true
+	}
+	timeout := p.config.HealthCheckTimeout
+	if timeout <= 0 {
+		timeout = DefaultPoolHealthCheckTimeout
+	}
+	if _, err := client.ping(timeout); err != nil {
+		client.log("WARNING: pooled connection to %s failed its health check: %v", client.addr(), err)
+		return false
+	}
+	return true
+}
+
+// discard drops an unhealthy entry; holders of the entry keep their
+// reference but it no longer returns to the pool.
+func (p *Pool) discard(entry *poolEntry) {
+	p.mu.Lock()
+	entry.refs--
+	if p.entries[entry.key] == entry {
+		delete(p.entries, entry.key)
+	}
+	refs := entry.refs
+	p.notifyLocked()
+	p.mu.Unlock()
+	if refs == 0 {
+		entry.client.closeConnection()
+	}
+}
+
+// abandon drops an entry whose holder gave up on it and closes the
+// connection right away, also for anyone else holding it.
+func (p *Pool) abandon(entry *poolEntry) {
+	p.mu.Lock()
+	if p.entries[entry.key] == entry {
+		delete(p.entries, entry.key)
+		p.notifyLocked()
+	}
+	p.mu.Unlock()
+	entry.client.closeConnection()
+}
+
+func (p *Pool) hostConnsLocked(addr string) int {
+	count := 0
+	for key := range p.entries {
+		if key.addr == addr {
+			count++
+		}
+	}
+	return count
+}
+
+func (p *Pool) idleEntryLocked(addr string) *poolEntry {
+	for key, entry := range p.entries {
+		if key.addr == addr && entry.client != nil && entry.refs == 0 {
+			return entry
+		}
+	}
+	return nil
+}
+
+func (p *Pool) notifyLocked() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// Release returns the connection to the pool. Calling it more than once is
+// harmless.
+func (pc *PooledClient) Release() {
+	pc.release.Do(func() {
+		pc.pool.release(pc.entry)
+	})
+}
+
+func (p *Pool) release(entry *poolEntry) {
+	p.mu.Lock()
+	entry.refs--
+	if entry.refs > 0 {
+		p.mu.Unlock()
+		return
+	}
+	pooled := p.entries[entry.key] == entry
+	maxIdle := p.config.MaxIdleTime
+	if maxIdle == 0 {
+		maxIdle = DefaultPoolMaxIdleTime
+	}
+	if pooled && !p.closed && maxIdle > 0 {
+		entry.idle = time.AfterFunc(maxIdle, func() {
+			p.expire(entry)
+		})
+		p.notifyLocked()
+		p.mu.Unlock()
+		return
+	}
+	if pooled {
+		delete(p.entries, entry.key)
+	}
+	p.notifyLocked()
+	p.mu.Unlock()
+	entry.client.closeConnection()
+}
+
+func (p *Pool) expire(entry *poolEntry) {
+	p.mu.Lock()
+	if entry.refs != 0 || p.entries[entry.key] != entry {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.entries, entry.key)
+	p.notifyLocked()
+	p.mu.Unlock()
+	entry.client.closeConnection()
+}
+
+// Close closes idle connections right away and the others when they are
+// released. Get fails afterwards.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	idle := []*poolEntry{}
+	for key, entry := range p.entries {
+		if entry.client == nil || entry.refs > 0 {
+			continue
+		}
+		if entry.idle != nil {
+			entry.idle.Stop()
+		}
+		idle = append(idle, entry)
+		delete(p.entries, key)
+	}
+	p.notifyLocked()
+	p.mu.Unlock()
+	for _, entry := range idle {
+		entry.client.closeConnection()
+	}
+}
+
+// Len reports the number of connections the pool holds, in use or idle.
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	count := 0
+	for _, entry := range p.entries {
+		if entry.client != nil {
+			count++
+		}
+	}
+	return count
+}
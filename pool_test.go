@@ -0,0 +1,152 @@
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testPoolConfig(t *testing.T, server *testSSHServer, user string) ClientConfig {
+	return ClientConfig{
+		User:          user,
+		Password:      "secret",
+		Host:          server.addr,
+		SSHFolderPath: t.TempDir(),
+	}
+}
+
+func TestPoolSharesConnectionsByKey(t *testing.T) {
+	server := newTestSSHServer(t, testSigner(t))
+	var dials int32
+	pool := NewPool(PoolConfig{}, WithGlobalKnownHostsFiles(), WithDialer(countingDialer(&dials)))
+	defer pool.Close()
+	config := testPoolConfig(t, server, "test")
+
+	first, err := pool.Get(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := pool.Get(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Client != second.Client || atomic.LoadInt32(&dials) != 1 {
+		t.Fatalf("expected one shared connection, got %d dials", dials)
+	}
+
+	other := config
+	other.User = "other"
+	third, err := pool.Get(context.Background(), other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.Client == first.Client || pool.Len() != 2 {
+		t.Fatalf("different users must not share a connection")
+	}
+	trust := config
+	trust.KnownHostsFile = filepath.Join(t.TempDir(), "known_hosts")
+	fourth, err := pool.Get(context.Background(), trust)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fourth.Client == first.Client || pool.Len() != 3 {
+		t.Fatalf("different known_hosts files must not share a connection")
+	}
+	first.Release()
+	first.Release()
+	second.Release()
+	third.Release()
+	fourth.Release()
+	if pool.Len() != 3 {
+		t.Fatalf("released connections should stay idle, pool has %d", pool.Len())
+	}
+
+	proxied := config
+	proxied.Dialer = countingDialer(&dials)
+	if _, err := pool.Get(context.Background(), proxied); err == nil {
+		t.Fatal("configs with their own Dialer must not be pooled")
+	}
+}
+
+func TestPoolClosesIdleConnections(t *testing.T) {
+	server := newTestSSHServer(t, testSigner(t))
+	var dials int32
+	pool := NewPool(PoolConfig{MaxIdleTime: 20 * time.Millisecond}, WithGlobalKnownHostsFiles(), WithDialer(countingDialer(&dials)))
+	defer pool.Close()
+	pc, err := pool.Get(context.Background(), testPoolConfig(t, server, "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc.Release()
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("idle connection was not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	<-pc.connClosed
+}
+
+func TestPoolReplacesDeadIdleConnection(t *testing.T) {
+	server := newTestSSHServer(t, testSigner(t))
+	var dials int32
+	pool := NewPool(PoolConfig{}, WithGlobalKnownHostsFiles(), WithDialer(countingDialer(&dials)))
+	defer pool.Close()
+	config := testPoolConfig(t, server, "test")
+	pc, err := pool.Get(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc.Release()
+	pc.client.Close()
+	<-pc.connClosed
+
+	fresh, err := pool.Get(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fresh.Release()
+	if fresh.Client == pc.Client || atomic.LoadInt32(&dials) != 2 {
+		t.Fatalf("dead connection was handed out again")
+	}
+}
+
+func TestPoolLimitsConnectionsPerHost(t *testing.T) {
+	server := newTestSSHServer(t, testSigner(t))
+	var dials int32
+	pool := NewPool(PoolConfig{MaxConnsPerHost: 1}, WithGlobalKnownHostsFiles(), WithDialer(countingDialer(&dials)))
+	defer pool.Close()
+	first, err := pool.Get(context.Background(), testPoolConfig(t, server, "first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Get(ctx, testPoolConfig(t, server, "second")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected Get to wait for a free slot, got %v", err)
+	}
+
+	got := make(chan *PooledClient)
+	go func() {
+		pc, err := pool.Get(context.Background(), testPoolConfig(t, server, "second"))
+		if err != nil {
+			t.Error(err)
+		}
+		got <- pc
+	}()
+	first.Release()
+	second := <-got
+	if second == nil {
+		return
+	}
+	defer second.Release()
+	if pool.Len() != 1 {
+		t.Fatalf("pool holds %d connections to one host", pool.Len())
+	}
+	<-first.connClosed
+}
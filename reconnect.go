@@ -0,0 +1,171 @@
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ReconnectPolicy controls how a client re-dials a dropped connection.
+// Zero fields take the defaults: 5 attempts, backing off from 500ms up to
+// 30s.
+type ReconnectPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+const (
+	defaultReconnectAttempts = 5
+	defaultReconnectBackoff  = 500 * time.Millisecond
+	defaultReconnectMax      = 30 * time.Second
+)
+
+var errClientClosed = errors.New("ssh: client has exited")
+
+// liveClient returns the connection to open sessions on. Lazy clients dial
+// here on first use and clients with a ReconnectPolicy re-dial when the
+// connection has dropped, using the same auth and host key settings.
+// Commands are never retried: a command whose connection drops fails, and
+// only the next call gets a new connection. ctx bounds dialing, including
+// waiting for a dial another caller started.
+func (c *Client) liveClient(ctx context.Context) (*ssh.Client, error) {
+	client, _, err := c.liveConnection(ctx)
+	return client, err
+}
+
+// liveConnection is liveClient that also returns the channel closed when
+// the returned connection ends.
+func (c *Client) liveConnection(ctx context.Context) (*ssh.Client, <-chan struct{}, error) {
+	c.connMu.Lock()
+	for {
+		if c.exited {
+			c.connMu.Unlock()
+			return nil, nil, errClientClosed
+		}
+		if c.client != nil && (c.reconnect == nil || !c.connectionClosed()) {
+			client, closed := c.client, c.connClosed
+			c.connMu.Unlock()
+			return client, closed, nil
+		}
+		if c.dialing == nil {
+			break
+		}
+		dialing := c.dialing
+		c.connMu.Unlock()
+		select {
+		case <-dialing:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+		c.connMu.Lock()
+	}
+	// Dial without holding connMu so that closing the client and callers
+	// giving up on ctx are not blocked by the backoff.
+	dialing := make(chan struct{})
+	c.dialing = dialing
+	stale := c.client
+	c.connMu.Unlock()
+	err := c.redial(ctx, stale)
+	c.connMu.Lock()
+	c.dialing = nil
+	close(dialing)
+	client, closed := c.client, c.connClosed
+	c.connMu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, closed, nil
+}
+
+func (c *Client) connectionClosed() bool {
+	select {
+	case <-c.connClosed:
+		return true
+	default:
+		return false
+	}
+}
+
+// redial replaces stale, which may be nil, with a new connection.
+func (c *Client) redial(ctx context.Context, stale *ssh.Client) error {
+	policy := ReconnectPolicy{MaxAttempts: 1}
+	if c.reconnect != nil {
+		policy = *c.reconnect
+	}
+	maxAttempts := policy.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultReconnectAttempts
+	}
+	backoff := policy.InitialBackoff
+	if backoff <= 0 {
+		backoff = defaultReconnectBackoff
+	}
+	maxBackoff := policy.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultReconnectMax
+	}
+
+	if stale != nil {
+		stale.Close()
+	}
+	for attempt := 1; ; attempt++ {
+		c.connMu.Lock()
+		exited := c.exited
+		c.connMu.Unlock()
+		if exited {
+			return errClientClosed
+		}
+		err := c.connect(ctx)
+		if err == nil {
+			if stale != nil {
+				c.log("Reconnected to %s after %d attempt(s).", c.addr(), attempt)
+			}
+			return nil
+		}
+		if attempt >= maxAttempts || !retryableDialError(err) || ctx.Err() != nil {
+			return err
+		}
+		c.log("WARNING: connecting to %s failed (attempt %d/%d): %v, retrying in %s", c.addr(), attempt, maxAttempts, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// retryableDialError reports whether err is a network failure worth another
+// attempt. Authentication and host key failures are not.
+func retryableDialError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF)
+}
+
+// connectionDropped reports whether a session failed to open on client
+// because the connection dropped, in which case opening it once more on a
+// new connection is safe since nothing has run yet.
+func (c *Client) connectionDropped(client *ssh.Client) bool {
+	if c.reconnect == nil {
+		return false
+	}
+	c.connMu.Lock()
+	current, closed := c.client, c.connClosed
+	c.connMu.Unlock()
+	if current != client {
+		return true
+	}
+	// The failed open and the transport noticing the drop race; give the
+	// latter a moment before deciding the connection is still fine.
+	select {
+	case <-closed:
+		return true
+	case <-time.After(time.Second):
+		return false
+	}
+}
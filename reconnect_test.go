@@ -0,0 +1,214 @@
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func countingDialer(count *int32) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		atomic.AddInt32(count, 1)
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+}
+
+func TestLazyClientConnectsOnFirstUse(t *testing.T) {
+	server := newTestSSHServer(t, testSigner(t))
+	var dials int32
+	client, err := Dial(context.Background(), ClientConfig{
+		User:          "test",
+		Password:      "secret",
+		Host:          server.addr,
+		SSHFolderPath: t.TempDir(),
+		Dialer:        countingDialer(&dials),
+		Lazy:          true,
+	}, WithGlobalKnownHostsFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.closeConnection()
+	if dials != 0 {
+		t.Fatalf("lazy client dialed %d times before use", dials)
+	}
+	if _, err := client.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&dials) != 1 {
+		t.Fatalf("expected one dial, got %d", dials)
+	}
+}
+
+func TestClientReconnectsAfterDrop(t *testing.T) {
+	server := newTestSSHServer(t, testSigner(t))
+	var dials int32
+	client, err := Dial(context.Background(), ClientConfig{
+		User:          "test",
+		Password:      "secret",
+		Host:          server.addr,
+		SSHFolderPath: t.TempDir(),
+		Dialer:        countingDialer(&dials),
+		Reconnect:     &ReconnectPolicy{InitialBackoff: time.Millisecond},
+	}, WithGlobalKnownHostsFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.closeConnection()
+
+	client.client.Close()
+	<-client.connClosed
+	if _, err := client.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&dials) != 2 {
+		t.Fatalf("expected a second dial, got %d", dials)
+	}
+}
+
+func TestClientWithoutReconnectKeepsDeadConnection(t *testing.T) {
+	server := newTestSSHServer(t, testSigner(t))
+	var dials int32
+	client, err := Dial(context.Background(), ClientConfig{
+		User:          "test",
+		Password:      "secret",
+		Host:          server.addr,
+		SSHFolderPath: t.TempDir(),
+		Dialer:        countingDialer(&dials),
+	}, WithGlobalKnownHostsFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.client.Close()
+	<-client.connClosed
+	if _, err := client.Ping(); err == nil {
+		t.Fatal("Ping should fail on a dropped connection")
+	}
+	if atomic.LoadInt32(&dials) != 1 {
+		t.Fatalf("client without a reconnect policy dialed %d times", dials)
+	}
+}
+
+func TestReconnectDoesNotRetryAuthFailure(t *testing.T) {
+	server := newTestSSHServer(t, testSigner(t))
+	var dials int32
+	client, err := Dial(context.Background(), ClientConfig{
+		User:          "test",
+		Password:      "wrong",
+		Host:          server.addr,
+		SSHFolderPath: t.TempDir(),
+		Dialer:        countingDialer(&dials),
+		Lazy:          true,
+		Reconnect:     &ReconnectPolicy{InitialBackoff: time.Millisecond},
+	}, WithGlobalKnownHostsFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Ping(); err == nil {
+		t.Fatal("expected an authentication error")
+	}
+	if atomic.LoadInt32(&dials) != 1 {
+		t.Fatalf("authentication failure was retried: %d dials", dials)
+	}
+}
+
+func TestLazyConnectTimeoutIsNotSessionTimeout(t *testing.T) {
+	client, err := Dial(context.Background(), ClientConfig{
+		User:           "test",
+		Password:       "secret",
+		Host:           "192.0.2.1",
+		SSHFolderPath:  t.TempDir(),
+		ConnectTimeout: 50 * time.Millisecond,
+		SessionTimeout: time.Second,
+		Lazy:           true,
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}, WithGlobalKnownHostsFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.closeConnection()
+	_, err = client.newSession(context.Background())
+	assertTimeoutPhase(t, err, TimeoutPhaseConnect)
+}
+
+func TestCloseDoesNotWaitForReconnectBackoff(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	var dials int32
+	client, err := Dial(context.Background(), ClientConfig{
+		User:          "test",
+		Password:      "secret",
+		Host:          addr,
+		SSHFolderPath: t.TempDir(),
+		Dialer:        countingDialer(&dials),
+		Lazy:          true,
+		Reconnect:     &ReconnectPolicy{MaxAttempts: 10, InitialBackoff: 300 * time.Millisecond},
+	}, WithGlobalKnownHostsFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinged := make(chan error, 1)
+	go func() {
+		_, err := client.Ping()
+		pinged <- err
+	}()
+	for atomic.LoadInt32(&dials) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	client.closeConnection()
+	if took := time.Since(start); took > 100*time.Millisecond {
+		t.Fatalf("closing waited %s for the reconnect backoff", took)
+	}
+	if err := <-pinged; !errors.Is(err, errClientClosed) {
+		t.Fatalf("expected the redial to stop after close, got %v", err)
+	}
+	if n := atomic.LoadInt32(&dials); n > 2 {
+		t.Fatalf("client kept dialing after close: %d dials", n)
+	}
+}
+
+func TestJumpHostInheritsReconnectPolicy(t *testing.T) {
+	bastion := newTestSSHServer(t, testSigner(t))
+	serveDirectTCPIP(bastion)
+	target := newTestSSHServer(t, testSigner(t))
+	dir := t.TempDir()
+	policy := &ReconnectPolicy{InitialBackoff: time.Millisecond}
+	client, err := Dial(context.Background(), ClientConfig{
+		User:          "test",
+		Password:      "secret",
+		Host:          target.addr,
+		SSHFolderPath: dir,
+		Reconnect:     policy,
+		Jump:          &ClientConfig{User: "test", Password: "secret", Host: bastion.addr, SSHFolderPath: dir},
+	}, WithGlobalKnownHostsFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.closeConnection()
+
+	jump := client.jumpClient
+	if jump.reconnect != policy {
+		t.Fatalf("jump host reconnect policy = %v", jump.reconnect)
+	}
+	jump.client.Close()
+	<-jump.connClosed
+	// The target's connection ran through the jump connection.
+	<-client.connClosed
+	if _, err := client.Ping(); err != nil {
+		t.Fatalf("target did not recover through a new jump connection: %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package sshclient
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Report collects the results of several fan-out runs, usually one per
+// command, and writes them as JSON or JUnit XML for CI systems.
+type Report struct {
+	Name string
+
+	mu   sync.Mutex
+	runs []reportRun
+}
+
+type reportRun struct {
+	name    string
+	results []CommandResult
+}
+
+func NewReport(name string) *Report {
+	return &Report{Name: name}
+}
+
+// Add records a run under name, typically the command. Results without a
+// Command, such as hosts that could not be reached, are given name.
+func (r *Report) Add(name string, result GroupResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	results := make([]CommandResult, len(result.Results))
+	for i, res := range result.Results {
+		if res.Command == "" {
+			res.Command = name
+		}
+		results[i] = res
+	}
+	r.runs = append(r.runs, reportRun{name: name, results: results})
+}
+
+func (r *Report) Summary() GroupSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	all := []CommandResult{}
+	for _, run := range r.runs {
+		all = append(all, run.results...)
+	}
+	return summarize(all)
+}
+
+func resultStatus(result CommandResult) string {
+	switch {
+	case result.OK():
+		return "passed"
+	case result.Skipped():
+		return "skipped"
+	}
+	return "failed"
+}
+
+type jsonReport struct {
+	Name    string          `json:"name"`
+	Summary jsonSummary     `json:"summary"`
+	Runs    []jsonReportRun `json:"runs"`
+}
+
+type jsonSummary struct {
+	Total   int `json:"total"`
+	Passed  int `json:"passed"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+}
+
+type jsonReportRun struct {
+	Name    string       `json:"name"`
+	Results []jsonResult `json:"results"`
+}
+
+type jsonResult struct {
+	Target   string    `json:"target"`
+	Command  string    `json:"command"`
+	Status   string    `json:"status"`
+	ExitCode int       `json:"exit_code"`
+	Stdout   string    `json:"stdout"`
+	Stderr   string    `json:"stderr"`
+	Error    string    `json:"error,omitempty"`
+	Started  time.Time `json:"started,omitzero"`
+	Duration float64   `json:"duration_seconds"`
+}
+
+// WriteJSON writes the report as one indented JSON document.
+func (r *Report) WriteJSON(w io.Writer) error {
+	summary := r.Summary()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := jsonReport{
+		Name: r.Name,
+		Summary: jsonSummary{
+			Total:   summary.Total,
+			Passed:  summary.Succeeded,
+			Failed:  summary.Failed,
+			Skipped: summary.Skipped,
+		},
+		Runs: []jsonReportRun{},
+	}
+	for _, run := range r.runs {
+		jsonRun := jsonReportRun{Name: run.name, Results: []jsonResult{}}
+		for _, result := range run.results {
+			res := jsonResult{
+				Target:   result.Target,
+				Command:  result.Command,
+				Status:   resultStatus(result),
+				ExitCode: result.ExitCode,
+				Stdout:   result.Stdout,
+				Stderr:   result.Stderr,
+				Started:  result.Started,
+				Duration: result.Duration.Seconds(),
+			}
+			if result.Err != nil {
+				res.Error = result.Err.Error()
+			}
+			jsonRun.Results = append(jsonRun.Results, res)
+		}
+		report.Runs = append(report.Runs, jsonRun)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr,omitempty"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure"`
+	Skipped   *junitSkipped `xml:"skipped"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// WriteJUnit writes the report as JUnit XML: a testsuite per run and a
+// testcase per host, named after the target with the command as class
+// name. Failures carry the error and exit code; stdout and stderr are
+// attached to every testcase.
+func (r *Report) WriteJUnit(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	suites := junitTestSuites{Name: r.Name}
+	var total time.Duration
+	for _, run := range r.runs {
+		suite := junitTestSuite{Name: run.name}
+		var started, ended time.Time
+		for _, result := range run.results {
+			testCase := junitTestCase{
+				Name:      result.Target,
+				ClassName: result.Command,
+				Time:      junitTime(result.Duration),
+				SystemOut: result.Stdout,
+				SystemErr: result.Stderr,
+			}
+			switch resultStatus(result) {
+			case "skipped":
+				testCase.Skipped = &junitSkipped{Message: result.Err.Error()}
+				suite.Skipped++
+			case "failed":
+				failure := &junitFailure{Message: result.Err.Error(), Type: "error", Text: result.Err.Error()}
+				if result.ExitCode >= 0 {
+					failure.Type = fmt.Sprintf("exit %d", result.ExitCode)
+					failure.Text = fmt.Sprintf("exit code %d\n%s", result.ExitCode, result.Stderr)
+				}
+				testCase.Failure = failure
+				suite.Failures++
+			}
+			if !result.Started.IsZero() {
+				if started.IsZero() || result.Started.Before(started) {
+					started = result.Started
+				}
+				if end := result.Started.Add(result.Duration); end.After(ended) {
+					ended = end
+				}
+			}
+			suite.Cases = append(suite.Cases, testCase)
+		}
+		elapsed := ended.Sub(started)
+		suite.Tests = len(suite.Cases)
+		suite.Time = junitTime(elapsed)
+		if !started.IsZero() {
+			suite.Timestamp = started.UTC().Format("2006-01-02T15:04:05")
+		}
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Skipped += suite.Skipped
+		total += elapsed
+		suites.Suites = append(suites.Suites, suite)
+	}
+	suites.Time = junitTime(total)
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,74 @@
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func testReport(t *testing.T) *Report {
+	t.Helper()
+	targets := newExecTargets(t, 2)
+	group := &Group{Targets: targets, Options: []ClientOption{WithGlobalKnownHostsFiles()}}
+	report := NewReport("smoke")
+	report.Add("echo ok", group.Run(context.Background(), Command("echo ok")))
+	report.Add("fail", group.Run(context.Background(), Command("fail")))
+	unreachable := &Group{Targets: []ClientConfig{{User: "test", Password: "secret", Host: "127.0.0.1:1", SSHFolderPath: t.TempDir()}}}
+	report.Add("uptime", unreachable.Run(context.Background(), Command("uptime")))
+	return report
+}
+
+func TestReportWriteJSON(t *testing.T) {
+	report := testReport(t)
+	buf := bytes.Buffer{}
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded jsonReport
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("%v\n%s", err, buf.String())
+	}
+	if decoded.Name != "smoke" || decoded.Summary != (jsonSummary{Total: 5, Passed: 2, Failed: 3}) || len(decoded.Runs) != 3 {
+		t.Fatalf("unexpected report %+v", decoded)
+	}
+	failed := decoded.Runs[1].Results[0]
+	if failed.Command != "fail" || failed.Status != "failed" || failed.ExitCode != 3 || failed.Stderr != "boom\n" {
+		t.Fatalf("unexpected result %+v", failed)
+	}
+	unreachable := decoded.Runs[2].Results[0]
+	if unreachable.Command != "uptime" || unreachable.ExitCode != -1 || unreachable.Error == "" {
+		t.Fatalf("unreachable host should carry the command and error: %+v", unreachable)
+	}
+}
+
+func TestReportWriteJUnit(t *testing.T) {
+	report := testReport(t)
+	buf := bytes.Buffer{}
+	if err := report.WriteJUnit(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), xml.Header) {
+		t.Fatalf("missing XML header:\n%s", buf.String())
+	}
+	var decoded junitTestSuites
+	if err := xml.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("%v\n%s", err, buf.String())
+	}
+	if decoded.Tests != 5 || decoded.Failures != 3 || len(decoded.Suites) != 3 {
+		t.Fatalf("unexpected totals tests=%d failures=%d suites=%d", decoded.Tests, decoded.Failures, len(decoded.Suites))
+	}
+	passed := decoded.Suites[0].Cases[0]
+	if passed.ClassName != "echo ok" || passed.Failure != nil || passed.SystemOut != "ok\n" {
+		t.Fatalf("unexpected passing testcase %+v", passed)
+	}
+	failure := decoded.Suites[1].Cases[0].Failure
+	if failure == nil || failure.Type != "exit 3" || !strings.Contains(failure.Text, "boom") {
+		t.Fatalf("unexpected failure %+v", failure)
+	}
+	if decoded.Suites[2].Cases[0].Failure.Type != "error" {
+		t.Fatalf("dial failures should be reported as errors")
+	}
+}
@@ -0,0 +1,144 @@
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// RollingStrategy runs a Group in batches: a canary batch first, then
+// batches of BatchSize hosts or BatchPercent of all hosts. The rollout
+// stops when the failed hosts exceed the threshold or a hook fails.
+type RollingStrategy struct {
+	// Canary is the size of the first batch. Zero means 1 and a negative
+	// value disables the canary batch.
+	Canary int
+	// BatchSize takes precedence over BatchPercent. When neither is set
+	// all hosts after the canary form one batch.
+	BatchSize    int
+	BatchPercent float64
+	// Pause is waited between batches.
+	Pause time.Duration
+
+	// The rollout aborts once more than MaxFailures hosts have failed, or
+	// more than MaxFailurePercent of all hosts when that is set. The
+	// zero value aborts on the first failure.
+	MaxFailures       int
+	MaxFailurePercent float64
+
+	// BeforeBatch and AfterBatch are health checks around every batch;
+	// an error aborts the rollout. The batch number starts at 1.
+	BeforeBatch func(ctx context.Context, batch int, targets []ClientConfig) error
+	AfterBatch  func(ctx context.Context, batch int, results []CommandResult) error
+}
+
+type RollingResult struct {
+	GroupResult
+	// Batches is the number of batches that were started.
+	Batches int
+	// Err tells why the rollout stopped early, or is nil.
+	Err error
+}
+
+var ErrRolloutAborted = errors.New("ssh: rollout aborted")
+
+func (s RollingStrategy) batchSizes(total int) []int {
+	sizes := []int{}
+	remaining := total
+	canary := s.Canary
+	if canary == 0 {
+		canary = 1
+	}
+	if canary > 0 && remaining > 0 {
+		canary = min(canary, remaining)
+		sizes = append(sizes, canary)
+		remaining -= canary
+	}
+	size := s.BatchSize
+	if size <= 0 && s.BatchPercent > 0 {
+		size = int(math.Ceil(float64(total) * s.BatchPercent / 100))
+	}
+	if size <= 0 {
+		size = remaining
+	}
+	for remaining > 0 {
+		batch := min(size, remaining)
+		sizes = append(sizes, batch)
+		remaining -= batch
+	}
+	return sizes
+}
+
+func (s RollingStrategy) tooManyFailures(failed, total int) bool {
+	if s.MaxFailurePercent > 0 {
+		return float64(failed)*100 > s.MaxFailurePercent*float64(total)
+	}
+	return failed > s.MaxFailures
+}
+
+// RunRolling runs op on the group's targets batch by batch. Every batch
+// uses the group's concurrency, timeout, pool and options; FailFast is
+// ignored in favour of the strategy's thresholds. Hosts never reached are
+// reported with ErrSkipped.
+func (g *Group) RunRolling(ctx context.Context, strategy RollingStrategy, op Operation) RollingResult {
+	start := time.Now()
+	log := optionsLog(g.Options)
+	log.sshPrint(fmt.Sprintf("Group.RunRolling start hosts=%d", len(g.Targets)))
+	results := make([]CommandResult, 0, len(g.Targets))
+	rolling := RollingResult{}
+	failed := 0
+	offset := 0
+	for batch, size := range strategy.batchSizes(len(g.Targets)) {
+		if batch > 0 && strategy.Pause > 0 {
+			select {
+			case <-time.After(strategy.Pause):
+			case <-ctx.Done():
+				rolling.Err = ctx.Err()
+			}
+		}
+		if rolling.Err == nil && ctx.Err() != nil {
+			rolling.Err = ctx.Err()
+		}
+		if rolling.Err != nil {
+			break
+		}
+
+		targets := g.Targets[offset : offset+size]
+		if strategy.BeforeBatch != nil {
+			if err := strategy.BeforeBatch(ctx, batch+1, targets); err != nil {
+				rolling.Err = fmt.Errorf("%w: before batch %d: %v", ErrRolloutAborted, batch+1, err)
+				break
+			}
+		}
+		rolling.Batches++
+		sub := *g
+		sub.Targets = targets
+		sub.FailFast = false
+		batchResult := sub.Run(ctx, op)
+		results = append(results, batchResult.Results...)
+		offset += size
+		failed += batchResult.Summary.Failed
+
+		if strategy.AfterBatch != nil {
+			if err := strategy.AfterBatch(ctx, batch+1, batchResult.Results); err != nil {
+				rolling.Err = fmt.Errorf("%w: after batch %d: %v", ErrRolloutAborted, batch+1, err)
+				break
+			}
+		}
+		if strategy.tooManyFailures(failed, len(g.Targets)) {
+			rolling.Err = fmt.Errorf("%w: %d of %d hosts failed", ErrRolloutAborted, failed, len(g.Targets))
+			break
+		}
+	}
+	for _, config := range g.Targets[offset:] {
+		results = append(results, CommandResult{Target: targetName(config), ExitCode: -1, Err: ErrSkipped})
+	}
+
+	rolling.Results = results
+	rolling.Summary = summarize(results)
+	rolling.Summary.Duration = time.Since(start)
+	log.sshPrint(fmt.Sprintf("Group.RunRolling done %s batches=%d err=%v", rolling.Summary, rolling.Batches, rolling.Err))
+	return rolling
+}
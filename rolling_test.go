@@ -0,0 +1,80 @@
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRollingStrategyBatchSizes(t *testing.T) {
+	tests := []struct {
+		strategy RollingStrategy
+		total    int
+		want     []int
+	}{
+		{RollingStrategy{BatchPercent: 30}, 10, []int{1, 3, 3, 3}},
+		{RollingStrategy{BatchSize: 4}, 10, []int{1, 4, 4, 1}},
+		{RollingStrategy{Canary: -1, BatchSize: 5}, 10, []int{5, 5}},
+		{RollingStrategy{Canary: 2}, 5, []int{2, 3}},
+		{RollingStrategy{}, 1, []int{1}},
+		{RollingStrategy{}, 0, []int{}},
+	}
+	for _, test := range tests {
+		if got := test.strategy.batchSizes(test.total); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%+v.batchSizes(%d) = %v, want %v", test.strategy, test.total, got, test.want)
+		}
+	}
+}
+
+func TestRunRollingAbortsAboveFailureThreshold(t *testing.T) {
+	targets := newExecTargets(t, 6)
+	failing := map[string]bool{targets[1].Host: true, targets[2].Host: true}
+	group := &Group{Targets: targets, Options: []ClientOption{WithGlobalKnownHostsFiles()}}
+	before := []int{}
+	after := []int{}
+	result := group.RunRolling(context.Background(), RollingStrategy{
+		BatchSize:   2,
+		MaxFailures: 1,
+		BeforeBatch: func(ctx context.Context, batch int, targets []ClientConfig) error {
+			before = append(before, len(targets))
+			return nil
+		},
+		AfterBatch: func(ctx context.Context, batch int, results []CommandResult) error {
+			after = append(after, batch)
+			return nil
+		},
+	}, func(ctx context.Context, client *Client, result *CommandResult) error {
+		if failing[client.addr()] {
+			return errors.New("health check failed")
+		}
+		return nil
+	})
+
+	if !errors.Is(result.Err, ErrRolloutAborted) {
+		t.Fatalf("expected the rollout to abort, got %v", result.Err)
+	}
+	if result.Batches != 2 || !reflect.DeepEqual(before, []int{1, 2}) || !reflect.DeepEqual(after, []int{1, 2}) {
+		t.Fatalf("unexpected batches %d, before %v, after %v", result.Batches, before, after)
+	}
+	s := result.Summary
+	if s.Succeeded != 1 || s.Failed != 2 || s.Skipped != 3 || len(result.Results) != 6 {
+		t.Fatalf("unexpected summary %s", s)
+	}
+}
+
+func TestRunRollingStopsWhenHookFails(t *testing.T) {
+	targets := newExecTargets(t, 3)
+	group := &Group{Targets: targets, Options: []ClientOption{WithGlobalKnownHostsFiles()}}
+	result := group.RunRolling(context.Background(), RollingStrategy{
+		BeforeBatch: func(ctx context.Context, batch int, targets []ClientConfig) error {
+			if batch == 2 {
+				return errors.New("load balancer unhealthy")
+			}
+			return nil
+		},
+	}, Command("echo ok"))
+	if !errors.Is(result.Err, ErrRolloutAborted) || result.Summary.Succeeded != 1 || result.Summary.Skipped != 2 {
+		t.Fatalf("unexpected result %s: %v", result.Summary, result.Err)
+	}
+}
@@ -0,0 +1,151 @@
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultMaxSessions matches the MaxSessions default of OpenSSH's sshd.
+const DefaultMaxSessions = 10
+
+const (
+	sessionOpenAttempts = 6
+	sessionOpenBackoff  = 100 * time.Millisecond
+	sessionOpenMaxWait  = 2 * time.Second
+)
+
+// clientSession holds one of the client's session slots until closed.
+type clientSession struct {
+	*ssh.Session
+	done    func()
+	release sync.Once
+}
+
+func (s *clientSession) Close() error {
+	err := s.Session.Close()
+	s.release.Do(s.done)
+	return err
+}
+
+// acquireSessionSlot waits until fewer than MaxSessions sessions are open
+// on the client, or ctx is done, and returns the function giving the slot
+// back.
+func (c *Client) acquireSessionSlot(ctx context.Context) (func(), error) {
+	c.sessionSlotsOnce.Do(func() {
+		max := c.maxSessions
+		if max == 0 {
+			max = DefaultMaxSessions
+		}
+		if max > 0 {
+			c.sessionSlots = make(chan struct{}, max)
+		}
+	})
+	if c.sessionSlots == nil {
+		return func() {}, nil
+	}
+	select {
+	case c.sessionSlots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return func() {
+		<-c.sessionSlots
+	}, nil
+}
+
+func (c *Client) newSession(ctx context.Context) (*clientSession, error) {
+	return c.openSessionWithSetup(ctx, nil)
+}
+
+// openSessionWithSetup opens a session and runs setup on it, giving up
+// after the session timeout. Waiting for a free session slot and
+// connecting do not count towards the timeout. A session that only opens
+// after that is closed right away. When reconnecting is enabled and the
+// connection turns out to be dead, the session is opened once more on a
+// new connection.
+func (c *Client) openSessionWithSetup(ctx context.Context, setup func(*ssh.Session) error) (*clientSession, error) {
+	release, err := c.acquireSessionSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 1; ; attempt++ {
+		// Connecting has its own timeouts and is not part of the session
+		// phase.
+		client, err := c.liveClient(ctx)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		session, err := c.openSessionOn(client, setup)
+		if err == nil {
+			return &clientSession{Session: session, done: release}, nil
+		}
+		if attempt > 1 || !c.connectionDropped(client) {
+			release()
+			return nil, err
+		}
+		c.sshPrint(fmt.Sprintf("newSession connection to %s dropped, reconnecting", c.addr()))
+	}
+}
+
+func (c *Client) openSessionOn(client *ssh.Client, setup func(*ssh.Session) error) (*ssh.Session, error) {
+	var mu sync.Mutex
+	gaveUp := false
+	var session *ssh.Session
+	timeout := timeoutOrDefault(c.sessionTimeout, DefaultSessionTimeout)
+	err := runWithTimeout(TimeoutPhaseSession, c.addr(), timeout, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		gaveUp = true
+	}, func() error {
+		sessionStart := time.Now()
+		c.sshPrint("NewSession start")
+		s, err := c.openChannelWithRetry(client)
+		c.sshPrint(fmt.Sprintf("NewSession done took %s", time.Since(sessionStart)))
+		if err != nil {
+			return err
+		}
+		if setup != nil {
+			err = setup(s)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil || gaveUp {
+			s.Close()
+			return err
+		}
+		session = s
+		return nil
+	})
+	return session, err
+}
+
+// openChannelWithRetry backs off and retries when the server refuses the
+// session for capacity reasons, which is how sshd reports MaxSessions or
+// other limits being reached by sessions the client does not know about.
+func (c *Client) openChannelWithRetry(client *ssh.Client) (*ssh.Session, error) {
+	backoff := sessionOpenBackoff
+	for attempt := 1; ; attempt++ {
+		session, err := client.NewSession()
+		if err == nil || attempt >= sessionOpenAttempts || !sessionCapacityError(err) {
+			return session, err
+		}
+		c.sshPrint(fmt.Sprintf("openChannelWithRetry %s refused a session (attempt %d): %v, retrying in %s", c.addr(), attempt, err, backoff))
+		time.Sleep(backoff)
+		backoff = min(backoff*2, sessionOpenMaxWait)
+	}
+}
+
+func sessionCapacityError(err error) bool {
+	var openErr *ssh.OpenChannelError
+	if !errors.As(err, &openErr) {
+		return false
+	}
+	return openErr.Reason == ssh.Prohibited || openErr.Reason == ssh.ResourceShortage
+}
@@ -0,0 +1,131 @@
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// serveSessions accepts session channels once reject returns false for the
+// n-th open.
+func serveSessions(server *testSSHServer, opens *int32, reject func(n int32) ssh.RejectionReason) {
+	server.handleConn = func(conn *ssh.ServerConn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request) {
+		go ssh.DiscardRequests(reqs)
+		for newChannel := range chans {
+			n := atomic.AddInt32(opens, 1)
+			if reason := reject(n); reason != 0 {
+				newChannel.Reject(reason, "no more sessions")
+				continue
+			}
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go ssh.DiscardRequests(requests)
+			go func() {
+				// Keep the channel until the client closes it.
+				buf := make([]byte, 1)
+				channel.Read(buf)
+				channel.Close()
+			}()
+		}
+	}
+}
+
+func dialSessionTestClient(t *testing.T, server *testSSHServer, maxSessions int) *Client {
+	t.Helper()
+	client, err := Dial(context.Background(), ClientConfig{
+		User:          "test",
+		Password:      "secret",
+		Host:          server.addr,
+		SSHFolderPath: t.TempDir(),
+		MaxSessions:   maxSessions,
+	}, WithGlobalKnownHostsFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.closeConnection)
+	return client
+}
+
+func TestMaxSessionsQueuesExcessSessions(t *testing.T) {
+	server := newTestSSHServer(t, testSigner(t))
+	var opens int32
+	serveSessions(server, &opens, func(int32) ssh.RejectionReason { return 0 })
+	client := dialSessionTestClient(t, server, 2)
+
+	first, err := client.newSession(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := client.newSession(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.newSession(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("waiting for a slot should honour ctx, got %v", err)
+	}
+	third := make(chan *clientSession)
+	go func() {
+		session, err := client.newSession(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		third <- session
+	}()
+	select {
+	case <-third:
+		t.Fatal("third session opened past MaxSessions")
+	case <-time.After(50 * time.Millisecond):
+	}
+	first.Close()
+	first.Close()
+	if session := <-third; session != nil {
+		session.Close()
+	}
+	second.Close()
+	if got := atomic.LoadInt32(&opens); got != 3 {
+		t.Fatalf("server saw %d session opens, want 3", got)
+	}
+}
+
+func TestNewSessionRetriesCapacityRejections(t *testing.T) {
+	server := newTestSSHServer(t, testSigner(t))
+	var opens int32
+	serveSessions(server, &opens, func(n int32) ssh.RejectionReason {
+		if n <= 2 {
+			return ssh.Prohibited
+		}
+		return 0
+	})
+	client := dialSessionTestClient(t, server, 0)
+
+	session, err := client.newSession(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Close()
+	if got := atomic.LoadInt32(&opens); got != 3 {
+		t.Fatalf("server saw %d session opens, want 3", got)
+	}
+}
+
+func TestNewSessionDoesNotRetryOtherRejections(t *testing.T) {
+	server := newTestSSHServer(t, testSigner(t))
+	var opens int32
+	serveSessions(server, &opens, func(int32) ssh.RejectionReason { return ssh.ConnectionFailed })
+	client := dialSessionTestClient(t, server, 0)
+
+	if _, err := client.newSession(context.Background()); err == nil {
+		t.Fatal("expected the rejection to be returned")
+	}
+	if got := atomic.LoadInt32(&opens); got != 1 {
+		t.Fatalf("server saw %d session opens, want 1", got)
+	}
+}
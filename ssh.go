@@ -2,20 +2,28 @@ package sshclient
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/user"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh"
 )
 
+// SSHFolderPathPackage is only read by NewClient, NewClientSSHKey,
+// NewClientSSHKeyPem and NewClientPasswordAuth.
+//
+// Deprecated: use ClientConfig.SSHFolderPath or WithSSHFolderPath.
 var SSHFolderPathPackage string
 
+// Deprecated: use ClientConfig.SSHFolderPath or WithSSHFolderPath.
 func SetSSHFolderPath(sshFolderPath string) {
 	SSHFolderPathPackage = sshFolderPath
 }
@@ -32,6 +40,36 @@ type Client struct {
 	sess                                                     *ssh.Session
 	username, password, sshFolderPath, sshKeyPem, host, port string
 	knownHost                                                bool
+	revokedHostKeysPath                                      string
+	hashKnownHosts                                           bool
+	hostKeyPolicy                                            HostKeyPolicy
+	pinnedFingerprints                                       []string
+	pinnedHostKeys                                           map[string][]string
+	hostKeyStore                                             HostKeyStore
+	userKnownHostsFile                                       string
+	globalKnownHostsFiles                                    []string
+	extraKnownHostsFiles                                     []string
+	disableHostKeyUpdates                                    bool
+	hostKeyApprover                                          HostKeyApprover
+	identityFiles                                            []string
+	jumpClient                                               *Client
+	dial                                                     func(ctx context.Context, network, addr string) (net.Conn, error)
+	authMethods                                              []ssh.AuthMethod
+	connectTimeout, handshakeTimeout, sessionTimeout         time.Duration
+	keepaliveInterval                                        time.Duration
+	keepaliveMaxMissed                                       int
+	lazy                                                     bool
+	reconnect                                                *ReconnectPolicy
+	connMu                                                   sync.Mutex
+	connClosed                                               chan struct{}
+	dialing                                                  chan struct{}
+	exited                                                   bool
+	maxSessions                                              int
+	sessionSlots                                             chan struct{}
+	sessionSlotsOnce                                         sync.Once
+	hostKeyAlgorithmOverride                                 []string
+	keyExchanges, ciphers, macs                              []string
+	logger                                                   Logger
 
 	stdout *Writer
 
@@ -41,124 +79,102 @@ type Client struct {
 	stackLog string
 }
 
-func NewClient(username, password, host, port string) (*Client, error) {
-	client := &Client{
-		username: username,
-		password: password,
-		host:     host,
-		port:     port,
-
-		stdout: &Writer{},
-	}
-	err := client.connect()
-	if err != nil {
-		return nil, err
-	}
-	client.stackLog = GetStack()
-	addClientToLog(client)
-	return client, nil
+func NewClient(username, password, host, port string, opts ...ClientOption) (*Client, error) {
+	return Dial(context.Background(), ClientConfig{
+		User:          username,
+		Password:      password,
+		Host:          host,
+		Port:          port,
+		SSHFolderPath: SSHFolderPathPackage,
+	}, opts...)
 }
 
-func NewClientSSHKey(username, password, sshFolderPath, host, port string) (*Client, error) {
+func NewClientSSHKey(username, password, sshFolderPath, host, port string, opts ...ClientOption) (*Client, error) {
 	start := time.Now()
-	sshPrint("NewClientSSHKey start")
-	client := &Client{
-		username:      username,
-		password:      password,
-		sshFolderPath: sshFolderPath,
-		host:          host,
-		port:          port,
-
-		stdout: &Writer{},
-	}
-	err := client.connect()
-	if err != nil {
-		sshPrint(fmt.Sprintf("NewClientSSHKey error took %s", time.Since(start)))
+	log := optionsLog(opts)
+	log.sshPrint("NewClientSSHKey start")
+	if sshFolderPath == "" {
+		sshFolderPath = SSHFolderPathPackage
+	}
+	client, err := Dial(context.Background(), ClientConfig{
+		User:          username,
+		Password:      password,
+		Host:          host,
+		Port:          port,
+		SSHFolderPath: sshFolderPath,
+	}, opts...)
+	if err != nil {
+		log.sshPrint(fmt.Sprintf("NewClientSSHKey error took %s", time.Since(start)))
 		return nil, err
 	}
-	client.stackLog = GetStack()
-	addClientToLog(client)
-	sshPrint(fmt.Sprintf("NewClientSSHKey done took %s", time.Since(start)))
+	log.sshPrint(fmt.Sprintf("NewClientSSHKey done took %s", time.Since(start)))
 	return client, nil
 }
 
-func NewClientSSHKeyPem(username, sshKeyPem, host, port string) (*Client, error) {
-	client := &Client{
-		username:  username,
-		sshKeyPem: sshKeyPem,
-		host:      host,
-		port:      port,
-
-		stdout: &Writer{},
-	}
-	err := client.connect()
-	if err != nil {
-		return nil, err
-	}
-	client.stackLog = GetStack()
-	addClientToLog(client)
-	return client, nil
+func NewClientSSHKeyPem(username, sshKeyPem, host, port string, opts ...ClientOption) (*Client, error) {
+	return Dial(context.Background(), ClientConfig{
+		User:           username,
+		Host:           host,
+		Port:           port,
+		PrivateKeyFile: sshKeyPem,
+		SSHFolderPath:  SSHFolderPathPackage,
+	}, opts...)
 }
 
-func NewClientPasswordAuth(username, password, host, port string) (*Client, error) {
-	client := &Client{
-		username: username,
-		password: password,
-		host:     host,
-		port:     port,
+func NewClientPasswordAuth(username, password, host, port string, opts ...ClientOption) (*Client, error) {
+	return Dial(context.Background(), ClientConfig{
+		User:          username,
+		Password:      password,
+		Host:          host,
+		Port:          port,
+		Auth:          []ssh.AuthMethod{ssh.Password(password)},
+		SSHFolderPath: SSHFolderPathPackage,
+	}, opts...)
+}
 
-		stdout: &Writer{},
+func (c *Client) getAuthMethods() ([]ssh.AuthMethod, error) {
+	if c.authMethods != nil {
+		return c.authMethods, nil
 	}
-	err := client.connectPassword()
+	authMethodList := []ssh.AuthMethod{}
+	authStart := time.Now()
+	c.sshPrint("getAuthMethodPublicKeys start")
+	method, err := c.getAuthMethodPublicKeys()
+	c.sshPrint(fmt.Sprintf("getAuthMethodPublicKeys done took %s", time.Since(authStart)))
 	if err != nil {
 		return nil, err
 	}
-	client.stackLog = GetStack()
-	addClientToLog(client)
-	return client, nil
-}
-
-func (c *Client) connectPassword() error {
-	// SSH client config
-	config := &ssh.ClientConfig{
-		User: c.username,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(c.password),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	if method != nil {
+		authMethodList = append(authMethodList, method)
 	}
-
-	// Connect to host
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:%s", c.host, c.port), config)
-	if err != nil {
-		return err
+	if c.password != "" {
+		authMethodList = append(authMethodList, ssh.Password(c.password))
 	}
-	c.client = client
-	return nil
+	return authMethodList, nil
 }
 
-func (c *Client) connect() error {
+func (c *Client) connect(ctx context.Context) error {
 	start := time.Now()
-	sshPrint("connect start")
-	authMethodList := []ssh.AuthMethod{
-		ssh.Password(c.password),
-	}
-
-	authStart := time.Now()
-	sshPrint("getAuthMethodPublicKeys start")
-	method, err := c.getAuthMethodPublicKeys()
+	c.sshPrint("connect start")
+	authMethodList, err := c.getAuthMethods()
 	if err != nil {
-		panic(err)
-	} else {
-		authMethodList = append([]ssh.AuthMethod{method}, authMethodList...)
+		c.sshPrint(fmt.Sprintf("connect error took %s", time.Since(start)))
+		return err
+	}
+	addr := c.addr()
+	algos := c.hostKeyAlgorithmOverride
+	if algos == nil {
+		algoStart := time.Now()
+		c.sshPrint("hostKeyAlgorithms start")
+		algos = c.hostKeyAlgorithms(addr)
+		c.sshPrint(fmt.Sprintf("hostKeyAlgorithms done took %s", time.Since(algoStart)))
 	}
-	sshPrint(fmt.Sprintf("getAuthMethodPublicKeys done took %s", time.Since(authStart)))
-	addr := fmt.Sprintf("%s:%s", c.host, c.port)
-	algoStart := time.Now()
-	sshPrint("hostKeyAlgorithms start")
-	algos := c.hostKeyAlgorithms(addr)
-	sshPrint(fmt.Sprintf("hostKeyAlgorithms done took %s", time.Since(algoStart)))
 	config := &ssh.ClientConfig{
+		Config: ssh.Config{
+			KeyExchanges: c.keyExchanges,
+			Ciphers:      c.ciphers,
+			MACs:         c.macs,
+		},
 		User:              c.username,
 		Auth:              authMethodList,
 		HostKeyCallback:   c.hostKeyCallback,
@@ -166,47 +182,48 @@ func (c *Client) connect() error {
 	}
 
 	dialStart := time.Now()
-	sshPrint("ssh.Dial start")
-	client, err := ssh.Dial("tcp", addr, config)
-	sshPrint(fmt.Sprintf("ssh.Dial done took %s", time.Since(dialStart)))
+	c.sshPrint("dialSSH start")
+	client, err := c.dialSSH(ctx, addr, config)
+	c.sshPrint(fmt.Sprintf("dialSSH done took %s", time.Since(dialStart)))
 	if err != nil {
-		sshPrint(fmt.Sprintf("connect error took %s", time.Since(start)))
+		c.sshPrint(fmt.Sprintf("connect error took %s", time.Since(start)))
 		return err
 	}
+	closed := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(closed)
+	}()
+	c.connMu.Lock()
+	if c.exited {
+		c.connMu.Unlock()
+		client.Close()
+		return errClientClosed
+	}
 	c.client = client
-	sshPrint(fmt.Sprintf("connect done took %s", time.Since(start)))
+	c.connClosed = closed
+	c.connMu.Unlock()
+	c.startKeepalive(client, closed)
+	c.sshPrint(fmt.Sprintf("connect done took %s", time.Since(start)))
 	return nil
 }
 
-func (c *Client) createNewSession() *ssh.Session {
-	start := time.Now()
-	sshPrint(fmt.Sprintf("createNewSession start caller=%s", sshCaller(2)))
-	sessionStart := time.Now()
-	sshPrint("NewSession start")
-	session, err := c.client.NewSession()
-	sshPrint(fmt.Sprintf("NewSession done took %s", time.Since(sessionStart)))
-	if err != nil {
-		panic(err)
-	}
+func (c *Client) addr() string {
+	return net.JoinHostPort(c.host, c.port)
+}
 
-	modes := ssh.TerminalModes{
-		ssh.ECHO:          0,     // disable echoing
-		ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
-		ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
-		ssh.OPOST:         0,
-	}
-	ptyStart := time.Now()
-	sshPrint("RequestPty start")
-	err = session.RequestPty("xterm", 80, 40, modes)
-	sshPrint(fmt.Sprintf("RequestPty done took %s", time.Since(ptyStart)))
+func (c *Client) createNewSession() *clientSession {
+	start := time.Now()
+	c.sshPrint(fmt.Sprintf("createNewSession start caller=%s", sshCaller(2)))
+	session, err := c.openSession()
 	if err != nil {
 		panic(err)
 	}
 
 	pipeStart := time.Now()
-	sshPrint("StdinPipe start")
+	c.sshPrint("StdinPipe start")
 	in, err := session.StdinPipe()
-	sshPrint(fmt.Sprintf("StdinPipe done took %s", time.Since(pipeStart)))
+	c.sshPrint(fmt.Sprintf("StdinPipe done took %s", time.Since(pipeStart)))
 	if err != nil {
 		panic(err)
 	}
@@ -215,28 +232,46 @@ func (c *Client) createNewSession() *ssh.Session {
 	session.Stdout = writer
 	session.Stderr = writer
 
-	sshPrint(fmt.Sprintf("createNewSession done took %s", time.Since(start)))
+	c.sshPrint(fmt.Sprintf("createNewSession done took %s", time.Since(start)))
 	return session
 }
 
+// openSession opens a session with a pty, bounded by the session timeout
+// like newSession.
+func (c *Client) openSession() (*clientSession, error) {
+	return c.openSessionWithSetup(context.Background(), func(s *ssh.Session) error {
+		modes := ssh.TerminalModes{
+			ssh.ECHO:          0,     // disable echoing
+			ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
+			ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
+			ssh.OPOST:         0,
+		}
+		ptyStart := time.Now()
+		c.sshPrint("RequestPty start")
+		err := s.RequestPty("xterm", 80, 40, modes)
+		c.sshPrint(fmt.Sprintf("RequestPty done took %s", time.Since(ptyStart)))
+		return err
+	})
+}
+
 func (c *Client) Run(cmd string, a ...interface{}) {
 	start := time.Now()
 	fullCmd := fmt.Sprintf(cmd, a...)
-	sshPrint(fmt.Sprintf("Run start cmd=%s", fullCmd))
+	c.sshPrint(fmt.Sprintf("Run start cmd=%s", fullCmd))
 	session := c.createNewSession()
 	defer session.Close()
 	runStart := time.Now()
-	sshPrint("Run session.Run start")
+	c.sshPrint("Run session.Run start")
 	err := session.Run(fullCmd)
-	sshPrint(fmt.Sprintf("Run session.Run done took %s", time.Since(runStart)))
+	c.sshPrint(fmt.Sprintf("Run session.Run done took %s", time.Since(runStart)))
 	if err != nil {
 		panic(err)
 	}
-	sshPrint(fmt.Sprintf("Run done took %s", time.Since(start)))
+	c.sshPrint(fmt.Sprintf("Run done took %s", time.Since(start)))
 }
 
 func (c *Client) RunYes(cmd string, a ...interface{}) {
-	session, err := c.client.NewSession()
+	session, err := c.newSession(context.Background())
 	if err != nil {
 		panic(err)
 	}
@@ -269,7 +304,7 @@ func (c *Client) RunYes(cmd string, a ...interface{}) {
 }
 
 func (c *Client) RunMultipleCmds(cmds []string, delayDuration time.Duration) {
-	session, err := c.client.NewSession()
+	session, err := c.newSession(context.Background())
 	if err != nil {
 		panic(err)
 	}
@@ -326,7 +361,7 @@ func (c *Client) PromptRun(suffixList, answerList []string, cmd string, a ...int
 
 func (c *Client) Output(cmd string) string {
 	start := time.Now()
-	sshPrint(fmt.Sprintf("Output start cmd=%s", cmd))
+	c.sshPrint(fmt.Sprintf("Output start cmd=%s", cmd))
 	session := c.createNewSession()
 	defer session.Close()
 
@@ -334,13 +369,13 @@ func (c *Client) Output(cmd string) string {
 	session.Stdout = &stdoutBuf
 	session.Stderr = &stdoutBuf
 	runStart := time.Now()
-	sshPrint("session.Run start")
+	c.sshPrint("session.Run start")
 	err := session.Run(cmd)
-	sshPrint(fmt.Sprintf("session.Run done took %s", time.Since(runStart)))
+	c.sshPrint(fmt.Sprintf("session.Run done took %s", time.Since(runStart)))
 	if err != nil {
 		panic(err)
 	}
-	sshPrint(fmt.Sprintf("Output done took %s", time.Since(start)))
+	c.sshPrint(fmt.Sprintf("Output done took %s", time.Since(start)))
 	return strings.Replace(stdoutBuf.b.String(), "\r", "", -1)
 	// return stdoutBuf.String()
 }
@@ -358,33 +393,33 @@ func (c *Client) OutputIgnoreError(cmd string) string {
 func (c *Client) SUDORun(cmd string, a ...interface{}) {
 	start := time.Now()
 	fullCmd := fmt.Sprintf("sudo %s", fmt.Sprintf(cmd, a...))
-	sshPrint(fmt.Sprintf("SUDORun start cmd=%s", fullCmd))
+	c.sshPrint(fmt.Sprintf("SUDORun start cmd=%s", fullCmd))
 	session := c.createNewSession()
 	defer session.Close()
 	runStart := time.Now()
-	sshPrint("SUDORun session.Run start")
+	c.sshPrint("SUDORun session.Run start")
 	err := session.Run(fullCmd)
-	sshPrint(fmt.Sprintf("SUDORun session.Run done took %s", time.Since(runStart)))
+	c.sshPrint(fmt.Sprintf("SUDORun session.Run done took %s", time.Since(runStart)))
 	if err != nil {
 		panic(err)
 	}
-	sshPrint(fmt.Sprintf("SUDORun done took %s", time.Since(start)))
+	c.sshPrint(fmt.Sprintf("SUDORun done took %s", time.Since(start)))
 }
 
 func (c *Client) SUDOWriteToFile(content, filePath string) {
 	start := time.Now()
-	sshPrint(fmt.Sprintf("SUDOWriteToFile start path=%s size=%d", filePath, len(content)))
+	c.sshPrint(fmt.Sprintf("SUDOWriteToFile start path=%s size=%d", filePath, len(content)))
 	session := c.createNewSession()
 	defer session.Close()
 	cmd := fmt.Sprintf("cat <<'EOF' | sudo tee %s\n%s\nEOF\n", filePath, content)
 	runStart := time.Now()
-	sshPrint("SUDOWriteToFile session.Run start")
+	c.sshPrint("SUDOWriteToFile session.Run start")
 	err := session.Run(cmd)
-	sshPrint(fmt.Sprintf("SUDOWriteToFile session.Run done took %s", time.Since(runStart)))
+	c.sshPrint(fmt.Sprintf("SUDOWriteToFile session.Run done took %s", time.Since(runStart)))
 	if err != nil {
 		panic(err)
 	}
-	sshPrint(fmt.Sprintf("SUDOWriteToFile done took %s", time.Since(start)))
+	c.sshPrint(fmt.Sprintf("SUDOWriteToFile done took %s", time.Since(start)))
 }
 
 func (c *Client) WriteToFile(content, filePath string) {
@@ -455,7 +490,7 @@ func (c *Client) PromptCreateNewPassword(password, cmd string, a ...interface{})
 }
 
 func (c *Client) DownloadFile(remoteFilePath, destFilePath string) {
-	session, err := c.client.NewSession()
+	session, err := c.newSession(context.Background())
 	if err != nil {
 		panic(err)
 	}
@@ -488,8 +523,8 @@ func (c *Client) DownloadFile(remoteFilePath, destFilePath string) {
 
 func (c *Client) UploadFile(sourceFilePath, remoteFilePath string) {
 	start := time.Now()
-	sshPrint(fmt.Sprintf("UploadFile start %s -> %s", sourceFilePath, remoteFilePath))
-	session, err := c.client.NewSession()
+	c.sshPrint(fmt.Sprintf("UploadFile start %s -> %s", sourceFilePath, remoteFilePath))
+	session, err := c.newSession(context.Background())
 	if err != nil {
 		panic(err)
 	}
@@ -518,7 +553,7 @@ func (c *Client) UploadFile(sourceFilePath, remoteFilePath string) {
 
 	cmd := fmt.Sprintf("/usr/bin/scp -t %s", remoteDir)
 	runStart := time.Now()
-	sshPrint("UploadFile scp start")
+	c.sshPrint("UploadFile scp start")
 	err = session.Start(cmd)
 	if err != nil {
 		panic(wrapUploadErr(sourceFilePath, remoteFilePath, err, stderrBuf.String()))
@@ -545,11 +580,11 @@ func (c *Client) UploadFile(sourceFilePath, remoteFilePath string) {
 	hostIn.Close()
 
 	err = session.Wait()
-	sshPrint(fmt.Sprintf("UploadFile scp done took %s", time.Since(runStart)))
+	c.sshPrint(fmt.Sprintf("UploadFile scp done took %s", time.Since(runStart)))
 	if err != nil {
 		panic(wrapUploadErr(sourceFilePath, remoteFilePath, err, stderrBuf.String()))
 	}
-	sshPrint(fmt.Sprintf("UploadFile done took %s", time.Since(start)))
+	c.sshPrint(fmt.Sprintf("UploadFile done took %s", time.Since(start)))
 }
 
 func firstErr(errList ...error) error {
@@ -590,7 +625,7 @@ func (c *Client) WriteBigFile(content string, remoteFilePath string) {
 
 func (c *Client) SUDOWriteBigFile(content string, remoteFilePath string) {
 	start := time.Now()
-	sshPrint(fmt.Sprintf("SUDOWriteBigFile start path=%s size=%d", remoteFilePath, len(content)))
+	c.sshPrint(fmt.Sprintf("SUDOWriteBigFile start path=%s size=%d", remoteFilePath, len(content)))
 	randFileName := RandSeq(15)
 	tempFilePath := fmt.Sprintf("/tmp/%v.tmp", randFileName)
 	err := ioutil.WriteFile(tempFilePath, []byte(content), 0777)
@@ -601,30 +636,34 @@ func (c *Client) SUDOWriteBigFile(content string, remoteFilePath string) {
 	tempFilePathDest := fmt.Sprintf("/tmp/%v.tmp", randFileNameDest)
 	c.UploadFile(tempFilePath, tempFilePathDest)
 	c.SUDORun("mv %v %v", tempFilePathDest, remoteFilePath)
-	sshPrint(fmt.Sprintf("SUDOWriteBigFile done took %s", time.Since(start)))
+	c.sshPrint(fmt.Sprintf("SUDOWriteBigFile done took %s", time.Since(start)))
 }
 
 func (c *Client) Exit() {
 	start := time.Now()
-	sshPrint("Exit start")
+	c.sshPrint("Exit start")
 	session := c.createNewSession()
 	defer session.Close()
 	runStart := time.Now()
-	sshPrint("Exit session.Run start")
+	c.sshPrint("Exit session.Run start")
 	err := session.Run("exit")
-	sshPrint(fmt.Sprintf("Exit session.Run done took %s", time.Since(runStart)))
+	c.sshPrint(fmt.Sprintf("Exit session.Run done took %s", time.Since(runStart)))
 	if err != nil {
 		panic(err)
 	}
 	closeStart := time.Now()
-	sshPrint("client.Close start")
+	c.sshPrint("client.Close start")
+	c.connMu.Lock()
+	c.exited = true
 	err = c.client.Close()
-	sshPrint(fmt.Sprintf("client.Close done took %s", time.Since(closeStart)))
+	c.connMu.Unlock()
+	c.sshPrint(fmt.Sprintf("client.Close done took %s", time.Since(closeStart)))
 	if err != nil {
 		panic(err)
 	}
 	removeClientFromLog(c)
-	sshPrint(fmt.Sprintf("Exit done took %s", time.Since(start)))
+	c.jumpClient.closeConnection()
+	c.sshPrint(fmt.Sprintf("Exit done took %s", time.Since(start)))
 }
 
 func SSHCopyId(username, password, host, port string) {
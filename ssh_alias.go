@@ -0,0 +1,130 @@
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+)
+
+const maxProxyJumpDepth = 8
+
+// NewClientFromAlias connects to a Host alias described in the ssh_config
+// file of the ssh folder. HostName, User, Port, IdentityFile, ProxyJump,
+// UserKnownHostsFile and StrictHostKeyChecking are honoured; explicit
+// options override the values from the file and also apply to jump hosts.
+func NewClientFromAlias(alias string, opts ...ClientOption) (*Client, error) {
+	start := time.Now()
+	probe := optionsLog(opts)
+	probe.sshPrint(fmt.Sprintf("NewClientFromAlias start alias=%s", alias))
+	cfg, err := LoadSSHConfig(probe.getSSHFolderPath())
+	if errors.Is(err, os.ErrNotExist) {
+		cfg = &SSHConfig{sshFolderPath: probe.getSSHFolderPath()}
+	} else if err != nil {
+		return nil, err
+	}
+	config, err := sshConfigClientConfig(cfg, alias, true, 0)
+	if err != nil {
+		probe.sshPrint(fmt.Sprintf("NewClientFromAlias error took %s", time.Since(start)))
+		return nil, err
+	}
+	client, err := Dial(context.Background(), config, opts...)
+	if err != nil {
+		probe.sshPrint(fmt.Sprintf("NewClientFromAlias error took %s", time.Since(start)))
+		return nil, err
+	}
+	probe.sshPrint(fmt.Sprintf("NewClientFromAlias done took %s", time.Since(start)))
+	return client, nil
+}
+
+// sshConfigClientConfig resolves spec against cfg. Like ssh, only the
+// ProxyJump of the target and of its first hop are followed.
+func sshConfigClientConfig(cfg *SSHConfig, spec string, followProxyJump bool, depth int) (ClientConfig, error) {
+	if depth > maxProxyJumpDepth {
+		return ClientConfig{}, fmt.Errorf("ProxyJump chain for %s is too long", spec)
+	}
+	target, err := ParseTarget(spec)
+	if err != nil {
+		return ClientConfig{}, err
+	}
+	hc := cfg.Resolve(target.Host)
+	if target.User != "" {
+		hc.User = target.User
+	}
+	if target.Port != "" {
+		hc.Port = target.Port
+	}
+	if hc.User == "" {
+		hc.User = currentUsername()
+	}
+
+	config := ClientConfig{
+		User:          hc.User,
+		Host:          hc.HostName,
+		Port:          hc.Port,
+		IdentityFiles: hc.IdentityFiles,
+		SSHFolderPath: cfg.sshFolderPath,
+	}
+	if len(hc.UserKnownHostsFiles) > 0 {
+		config.KnownHostsFile = hc.UserKnownHostsFiles[0]
+		config.ExtraKnownHostsFiles = hc.UserKnownHostsFiles[1:]
+	}
+	if policy, ok := hc.HostKeyPolicy(); ok {
+		config.HostKeyPolicy = policy
+	}
+
+	if followProxyJump && hc.ProxyJump != "" && !strings.EqualFold(hc.ProxyJump, "none") {
+		var via *ClientConfig
+		for i, hop := range strings.Split(hc.ProxyJump, ",") {
+			jump, err := sshConfigClientConfig(cfg, hop, i == 0, depth+1)
+			if err != nil {
+				return ClientConfig{}, fmt.Errorf("ProxyJump %s: %v", hop, err)
+			}
+			if via != nil {
+				jump.Jump = via
+			}
+			via = &jump
+		}
+		config.Jump = via
+	}
+	return config, nil
+}
+
+func currentUsername() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// dialThrough makes the client connect through jump, which is closed
+// together with the client.
+func (c *Client) dialThrough(jump *Client) {
+	c.jumpClient = jump
+	c.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		client, err := jump.liveClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return client.DialContext(ctx, network, addr)
+	}
+}
+
+// closeConnection closes the connection and every jump host behind it.
+func (c *Client) closeConnection() {
+	if c == nil {
+		return
+	}
+	c.connMu.Lock()
+	c.exited = true
+	if c.client != nil {
+		c.client.Close()
+	}
+	c.connMu.Unlock()
+	removeClientFromLog(c)
+	c.jumpClient.closeConnection()
+}
@@ -0,0 +1,310 @@
+package sshclient
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const maxSSHConfigIncludeDepth = 16
+
+type sshConfigOption struct {
+	key    string
+	values []string
+}
+
+type sshConfigBlock struct {
+	// match is "" for options before the first Host line, "host" for Host
+	// blocks and "match" for Match blocks.
+	match    string
+	patterns []string
+	options  []sshConfigOption
+}
+
+// SSHConfig is a parsed ssh_config(5) file. Only the keywords needed to
+// build a Client are interpreted; every other keyword is kept but ignored.
+type SSHConfig struct {
+	sshFolderPath string
+	blocks        []*sshConfigBlock
+}
+
+// HostConfig holds the settings that apply to one Host alias.
+type HostConfig struct {
+	Alias                 string
+	HostName              string
+	User                  string
+	Port                  string
+	IdentityFiles         []string
+	ProxyJump             string
+	UserKnownHostsFiles   []string
+	StrictHostKeyChecking string
+}
+
+func LoadSSHConfig(sshFolderPath string) (*SSHConfig, error) {
+	return ParseSSHConfig(filepath.Join(sshFolderPath, "config"), sshFolderPath)
+}
+
+// ParseSSHConfig reads path. Relative Include paths are resolved against
+// sshFolderPath, like OpenSSH does for the user configuration.
+func ParseSSHConfig(path, sshFolderPath string) (*SSHConfig, error) {
+	cfg := &SSHConfig{sshFolderPath: sshFolderPath}
+	block := &sshConfigBlock{}
+	cfg.blocks = append(cfg.blocks, block)
+	if _, err := cfg.parseFile(path, block, 0); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *SSHConfig) parseFile(path string, block *sshConfigBlock, depth int) (*sshConfigBlock, error) {
+	if depth > maxSSHConfigIncludeDepth {
+		return nil, fmt.Errorf("ssh config %s: too many nested includes", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Lines after an included file keep applying to the block that
+	// contained the Include, even when the included file opened new ones.
+	parent := block
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		key, values, err := splitSSHConfigLine(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("ssh config %s:%d: %v", path, lineNum, err)
+		}
+		switch key {
+		case "":
+			continue
+		case "host":
+			block = &sshConfigBlock{match: "host", patterns: values}
+			parent = block
+			cfg.blocks = append(cfg.blocks, block)
+		case "match":
+			block = &sshConfigBlock{match: "match", patterns: values}
+			parent = block
+			cfg.blocks = append(cfg.blocks, block)
+		case "include":
+			for _, pattern := range values {
+				included, err := cfg.includeFiles(pattern)
+				if err != nil {
+					return nil, fmt.Errorf("ssh config %s:%d: %v", path, lineNum, err)
+				}
+				for _, includedPath := range included {
+					if _, err := cfg.parseFile(includedPath, block, depth+1); err != nil {
+						return nil, err
+					}
+				}
+			}
+			if cfg.blocks[len(cfg.blocks)-1] != parent {
+				block = &sshConfigBlock{match: parent.match, patterns: parent.patterns}
+				cfg.blocks = append(cfg.blocks, block)
+			}
+		default:
+			block.options = append(block.options, sshConfigOption{key: key, values: values})
+		}
+	}
+	return block, scanner.Err()
+}
+
+func (cfg *SSHConfig) includeFiles(pattern string) ([]string, error) {
+	pattern = expandHomeDir(pattern)
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(cfg.sshFolderPath, pattern)
+	}
+	return filepath.Glob(pattern)
+}
+
+func splitSSHConfigLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return "", nil, fmt.Errorf("missing value for %s", line)
+	}
+	key := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimPrefix(rest, "=")
+	values, err := splitSSHConfigArgs(rest)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(values) == 0 {
+		return "", nil, fmt.Errorf("missing value for %s", key)
+	}
+	return key, values, nil
+}
+
+func splitSSHConfigArgs(s string) ([]string, error) {
+	args := []string{}
+	var current strings.Builder
+	inQuote := false
+	hasArg := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			hasArg = true
+		case (r == ' ' || r == '\t') && !inQuote:
+			if hasArg {
+				args = append(args, current.String())
+				current.Reset()
+				hasArg = false
+			}
+		default:
+			current.WriteRune(r)
+			hasArg = true
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if hasArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// matchSSHConfigPatterns applies the ssh_config(5) PATTERNS rules: any
+// negated match rejects, otherwise one positive match is required.
+func matchSSHConfigPatterns(patterns []string, host string) bool {
+	matched := false
+	for _, list := range patterns {
+		for _, pattern := range strings.Split(list, ",") {
+			negate := strings.HasPrefix(pattern, "!")
+			pattern = strings.TrimPrefix(pattern, "!")
+			if !wildcardMatch(strings.ToLower(pattern), strings.ToLower(host)) {
+				continue
+			}
+			if negate {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+func (b *sshConfigBlock) applies(alias, hostName string) bool {
+	switch b.match {
+	case "":
+		return true
+	case "host":
+		return matchSSHConfigPatterns(b.patterns, alias)
+	}
+	// Only "Match all" and "Match host" are understood. Blocks using any
+	// other criteria never apply rather than applying too broadly.
+	if len(b.patterns) == 1 && strings.EqualFold(b.patterns[0], "all") {
+		return true
+	}
+	if len(b.patterns) != 2 || !strings.EqualFold(b.patterns[0], "host") {
+		return false
+	}
+	if hostName == "" {
+		hostName = alias
+	}
+	return matchSSHConfigPatterns(b.patterns[1:], hostName)
+}
+
+// Resolve returns the settings for alias. As in OpenSSH the first value
+// obtained for a keyword wins, except IdentityFile which accumulates.
+func (cfg *SSHConfig) Resolve(alias string) HostConfig {
+	hc := HostConfig{Alias: alias}
+	for _, block := range cfg.blocks {
+		if !block.applies(alias, hc.HostName) {
+			continue
+		}
+		for _, opt := range block.options {
+			value := opt.values[0]
+			switch opt.key {
+			case "hostname":
+				if hc.HostName == "" {
+					hc.HostName = value
+				}
+			case "user":
+				if hc.User == "" {
+					hc.User = value
+				}
+			case "port":
+				if hc.Port == "" {
+					hc.Port = value
+				}
+			case "identityfile":
+				hc.IdentityFiles = append(hc.IdentityFiles, value)
+			case "proxyjump":
+				if hc.ProxyJump == "" {
+					hc.ProxyJump = value
+				}
+			case "userknownhostsfile":
+				if hc.UserKnownHostsFiles == nil {
+					hc.UserKnownHostsFiles = append([]string{}, opt.values...)
+				}
+			case "stricthostkeychecking":
+				if hc.StrictHostKeyChecking == "" {
+					hc.StrictHostKeyChecking = strings.ToLower(value)
+				}
+			}
+		}
+	}
+
+	if hc.HostName == "" {
+		hc.HostName = alias
+	}
+	hc.HostName = strings.ReplaceAll(hc.HostName, "%h", alias)
+	if hc.Port == "" {
+		hc.Port = "22"
+	}
+	for i, path := range hc.IdentityFiles {
+		hc.IdentityFiles[i] = hc.expandPath(path, cfg.sshFolderPath)
+	}
+	for i, path := range hc.UserKnownHostsFiles {
+		hc.UserKnownHostsFiles[i] = hc.expandPath(path, cfg.sshFolderPath)
+	}
+	return hc
+}
+
+func (hc HostConfig) expandPath(path, sshFolderPath string) string {
+	replacer := strings.NewReplacer("%%", "%", "%h", hc.HostName, "%n", hc.Alias, "%p", hc.Port, "%r", hc.User, "%d", homeDir())
+	path = expandHomeDir(replacer.Replace(path))
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(sshFolderPath, path)
+	}
+	return path
+}
+
+func (hc HostConfig) HostKeyPolicy() (HostKeyPolicy, bool) {
+	switch hc.StrictHostKeyChecking {
+	case "yes", "ask":
+		return HostKeyPolicyStrict, true
+	case "accept-new":
+		return HostKeyPolicyAcceptNew, true
+	case "no", "off":
+		return HostKeyPolicyInsecure, true
+	}
+	return HostKeyPolicyAcceptNew, false
+}
+
+func homeDir() string {
+	if home, err := os.UserHomeDir(); err == nil {
+		return home
+	}
+	return os.Getenv("HOME")
+}
+
+func expandHomeDir(path string) string {
+	if path == "~" {
+		return homeDir()
+	}
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(homeDir(), path[2:])
+	}
+	return path
+}
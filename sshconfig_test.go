@@ -0,0 +1,239 @@
+package sshclient
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func writeSSHConfig(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSSHConfigResolveFirstValueWins(t *testing.T) {
+	dir := t.TempDir()
+	writeSSHConfig(t, dir, "config", `
+# comment
+Host web1 web2
+    HostName %h.example.com
+    User deploy
+    IdentityFile keys/web_%r
+
+Host web* !web3
+    Port 2222
+    User ignored
+    IdentityFile ~/.ssh/shared
+
+Host *
+    Port 22
+    StrictHostKeyChecking accept-new
+    UserKnownHostsFile known_hosts.d/%n other
+`)
+	cfg, err := LoadSSHConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hc := cfg.Resolve("web1")
+	if hc.HostName != "web1.example.com" || hc.User != "deploy" || hc.Port != "2222" {
+		t.Fatalf("unexpected config %+v", hc)
+	}
+	wantIdentities := []string{filepath.Join(dir, "keys/web_deploy"), filepath.Join(homeDir(), ".ssh/shared")}
+	if !reflect.DeepEqual(hc.IdentityFiles, wantIdentities) {
+		t.Fatalf("IdentityFiles = %v, want %v", hc.IdentityFiles, wantIdentities)
+	}
+	if hc.UserKnownHostsFiles[0] != filepath.Join(dir, "known_hosts.d/web1") {
+		t.Fatalf("UserKnownHostsFiles = %v", hc.UserKnownHostsFiles)
+	}
+	if policy, ok := hc.HostKeyPolicy(); !ok || policy != HostKeyPolicyAcceptNew {
+		t.Fatalf("HostKeyPolicy = %v, %v", policy, ok)
+	}
+
+	hc = cfg.Resolve("web3")
+	if hc.HostName != "web3" || hc.Port != "22" || hc.User != "" {
+		t.Fatalf("negated pattern applied: %+v", hc)
+	}
+}
+
+func TestSSHConfigIncludeAndMatch(t *testing.T) {
+	dir := t.TempDir()
+	writeSSHConfig(t, dir, "config.d/db", `
+Host db
+    HostName db.internal
+`)
+	writeSSHConfig(t, dir, "config", `
+Host db
+    Include config.d/*
+    User admin
+
+Match host db.internal
+    Port 2200
+
+Match user root
+    Port 1
+`)
+	cfg, err := LoadSSHConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc := cfg.Resolve("db")
+	if hc.HostName != "db.internal" || hc.User != "admin" || hc.Port != "2200" {
+		t.Fatalf("unexpected config %+v", hc)
+	}
+}
+
+func TestSSHConfigResolveDoesNotShareExpandedPaths(t *testing.T) {
+	dir := t.TempDir()
+	writeSSHConfig(t, dir, "config", `
+Host *
+    UserKnownHostsFile known_hosts_%n
+`)
+	cfg, err := LoadSSHConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, alias := range []string{"web1", "web2"} {
+		want := []string{filepath.Join(dir, "known_hosts_"+alias)}
+		if got := cfg.Resolve(alias).UserKnownHostsFiles; !reflect.DeepEqual(got, want) {
+			t.Fatalf("Resolve(%s) known hosts = %v, want %v", alias, got, want)
+		}
+	}
+}
+
+func TestSSHConfigRejectsIncludeLoop(t *testing.T) {
+	dir := t.TempDir()
+	writeSSHConfig(t, dir, "config", "Include config\n")
+	if _, err := LoadSSHConfig(dir); err == nil {
+		t.Fatal("expected an error for a recursive Include")
+	}
+}
+
+// serveDirectTCPIP lets the test server act as a jump host.
+func serveDirectTCPIP(server *testSSHServer) {
+	server.handleConn = func(conn *ssh.ServerConn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request) {
+		go ssh.DiscardRequests(reqs)
+		for newChannel := range chans {
+			if newChannel.ChannelType() != "direct-tcpip" {
+				newChannel.Reject(ssh.Prohibited, "not supported by test server")
+				continue
+			}
+			var target struct {
+				Host       string
+				Port       uint32
+				OriginHost string
+				OriginPort uint32
+			}
+			if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			upstream, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+			if err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				upstream.Close()
+				continue
+			}
+			go ssh.DiscardRequests(requests)
+			go func() {
+				io.Copy(channel, upstream)
+				channel.CloseWrite()
+			}()
+			go func() {
+				io.Copy(upstream, channel)
+				upstream.Close()
+			}()
+		}
+	}
+}
+
+func TestNewClientFromAliasThroughProxyJump(t *testing.T) {
+	bastion := newTestSSHServer(t, testSigner(t))
+	serveDirectTCPIP(bastion)
+	target := newTestSSHServer(t, testSigner(t))
+	bastionHost, bastionPort := bastion.hostPort()
+	targetHost, targetPort := target.hostPort()
+
+	dir := t.TempDir()
+	writeSSHConfig(t, dir, "config", `
+Host bastion
+    HostName `+bastionHost+`
+    Port `+bastionPort+`
+
+Host app
+    HostName `+targetHost+`
+    Port `+targetPort+`
+    User deploy
+    ProxyJump ops@bastion
+    UserKnownHostsFile app_known_hosts
+`)
+	client, err := NewClientFromAlias("app", WithSSHFolderPath(dir), WithPassword("secret"), WithGlobalKnownHostsFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.closeConnection()
+
+	if client.username != "deploy" || client.jumpClient == nil || client.jumpClient.username != "ops" {
+		t.Fatalf("unexpected client chain %+v", client)
+	}
+	if client.knownHostsPath() != filepath.Join(dir, "app_known_hosts") {
+		t.Fatalf("knownHostsPath = %s", client.knownHostsPath())
+	}
+	entries, err := client.KnownHosts().List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected the target host key to be recorded, got %v", entries)
+	}
+}
+
+func TestNewClientFromAliasTrustsExtraUserKnownHostsFiles(t *testing.T) {
+	signer := testSigner(t)
+	target := newTestSSHServer(t, signer)
+	targetHost, targetPort := target.hostPort()
+
+	dir := t.TempDir()
+	shared := filepath.Join(dir, "shared_known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(target.addr)}, signer.PublicKey())
+	if err := os.WriteFile(shared, []byte(line+"\n"), 0400); err != nil {
+		t.Fatal(err)
+	}
+	writeSSHConfig(t, dir, "config", `
+Host app
+    HostName `+targetHost+`
+    Port `+targetPort+`
+    UserKnownHostsFile app_known_hosts shared_known_hosts
+    StrictHostKeyChecking yes
+`)
+	client, err := NewClientFromAlias("app", WithSSHFolderPath(dir), WithPassword("secret"), WithGlobalKnownHostsFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.closeConnection()
+
+	if client.hostKeyPolicy != HostKeyPolicyStrict {
+		t.Fatalf("hostKeyPolicy = %v", client.hostKeyPolicy)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "app_known_hosts")); len(content) != 0 {
+		t.Fatalf("expected nothing written for a key found in the extra file, got %q", content)
+	}
+}
@@ -0,0 +1,132 @@
+package sshclient
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Target is a parsed destination. Host never carries IPv6 brackets and
+// Port is empty when the target did not name one.
+type Target struct {
+	User string
+	Host string
+	Port string
+}
+
+// ParseTarget accepts host, user@host, user@host:port, [::1]:2222, bare
+// IPv6 literals and ssh://user@host:port URIs.
+func ParseTarget(target string) (Target, error) {
+	if strings.HasPrefix(target, "ssh://") {
+		return parseTargetURI(target)
+	}
+	t := Target{}
+	rest := target
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		t.User = rest[:i]
+		rest = rest[i+1:]
+		if t.User == "" {
+			return Target{}, fmt.Errorf("invalid target %q: empty user", target)
+		}
+	}
+	switch {
+	case strings.HasPrefix(rest, "["):
+		end := strings.Index(rest, "]")
+		if end < 0 {
+			return Target{}, fmt.Errorf("invalid target %q: missing ']'", target)
+		}
+		t.Host = rest[1:end]
+		rest = rest[end+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				return Target{}, fmt.Errorf("invalid target %q: unexpected %q after host", target, rest)
+			}
+			t.Port = rest[1:]
+			if t.Port == "" {
+				return Target{}, fmt.Errorf("invalid target %q: empty port", target)
+			}
+		}
+	case strings.Count(rest, ":") == 1:
+		t.Host, t.Port, _ = strings.Cut(rest, ":")
+		if t.Port == "" {
+			return Target{}, fmt.Errorf("invalid target %q: empty port", target)
+		}
+	default:
+		// No colon, or an unbracketed IPv6 literal which cannot carry a port.
+		t.Host = rest
+	}
+	return t, t.validate(target)
+}
+
+func parseTargetURI(target string) (Target, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return Target{}, fmt.Errorf("invalid target %q: %v", target, err)
+	}
+	if _, ok := u.User.Password(); ok {
+		return Target{}, fmt.Errorf("invalid target %q: passwords are not accepted in ssh URIs", target)
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return Target{}, fmt.Errorf("invalid target %q: ssh URIs cannot have a path or query", target)
+	}
+	t := Target{Host: u.Hostname(), Port: u.Port()}
+	if u.User != nil {
+		t.User = u.User.Username()
+	}
+	if strings.HasSuffix(u.Host, ":") {
+		return Target{}, fmt.Errorf("invalid target %q: empty port", target)
+	}
+	return t, t.validate(target)
+}
+
+func (t Target) validate(target string) error {
+	if t.Host == "" {
+		return fmt.Errorf("invalid target %q: empty host", target)
+	}
+	if strings.ContainsAny(t.Host, "[]@/ ") {
+		return fmt.Errorf("invalid target %q: bad host %q", target, t.Host)
+	}
+	if t.Port != "" {
+		port, err := strconv.Atoi(t.Port)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid target %q: bad port %q", target, t.Port)
+		}
+	}
+	return nil
+}
+
+// Addr returns host:port for dialing, bracketing IPv6 literals and
+// defaulting to port 22.
+func (t Target) Addr() string {
+	port := t.Port
+	if port == "" {
+		port = "22"
+	}
+	return net.JoinHostPort(t.Host, port)
+}
+
+func (t Target) String() string {
+	s := t.Host
+	if t.Port != "" {
+		s = net.JoinHostPort(t.Host, t.Port)
+	} else if strings.Contains(s, ":") {
+		s = "[" + s + "]"
+	}
+	if t.User != "" {
+		s = t.User + "@" + s
+	}
+	return s
+}
+
+// normalizeHost spells any ParseTarget form the way known_hosts does: host
+// alone for port 22, [host]:port otherwise. The user part is dropped.
+func normalizeHost(addr string) string {
+	if t, err := ParseTarget(addr); err == nil {
+		addr = t.Addr()
+	}
+	return knownhosts.Normalize(addr)
+}
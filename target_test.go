@@ -0,0 +1,74 @@
+package sshclient
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Target
+		addr string
+	}{
+		{"example.com", Target{Host: "example.com"}, "example.com:22"},
+		{"deploy@example.com", Target{User: "deploy", Host: "example.com"}, "example.com:22"},
+		{"deploy@example.com:2222", Target{User: "deploy", Host: "example.com", Port: "2222"}, "example.com:2222"},
+		{"[::1]:2222", Target{Host: "::1", Port: "2222"}, "[::1]:2222"},
+		{"root@[fe80::1]", Target{User: "root", Host: "fe80::1"}, "[fe80::1]:22"},
+		{"::1", Target{Host: "::1"}, "[::1]:22"},
+		{"me@corp.com@bastion", Target{User: "me@corp.com", Host: "bastion"}, "bastion:22"},
+		{"ssh://deploy@example.com:2200", Target{User: "deploy", Host: "example.com", Port: "2200"}, "example.com:2200"},
+		{"ssh://[2001:db8::5]", Target{Host: "2001:db8::5"}, "[2001:db8::5]:22"},
+	}
+	for _, test := range tests {
+		got, err := ParseTarget(test.in)
+		if err != nil {
+			t.Errorf("ParseTarget(%q): %v", test.in, err)
+			continue
+		}
+		if got != test.want || got.Addr() != test.addr {
+			t.Errorf("ParseTarget(%q) = %+v (%s), want %+v (%s)", test.in, got, got.Addr(), test.want, test.addr)
+		}
+	}
+
+	for _, in := range []string{"", "@host", "host:", "host:ssh", "host:70000", "[::1", "[::1]x", "ssh://user:pw@host", "ssh://host/path", "ssh://host:"} {
+		if got, err := ParseTarget(in); err == nil {
+			t.Errorf("ParseTarget(%q) = %+v, want an error", in, got)
+		}
+	}
+}
+
+func TestNormalizeHostMatchesKnownHostsSpelling(t *testing.T) {
+	tests := map[string]string{
+		"example.com":                "example.com",
+		"deploy@example.com:22":      "example.com",
+		"ssh://example.com:2222":     "[example.com]:2222",
+		"[::1]":                      "::1",
+		"root@[::1]:2222":            "[::1]:2222",
+		"ssh://deploy@[2001:db8::5]": "2001:db8::5",
+	}
+	for in, want := range tests {
+		if got := normalizeHost(in); got != want {
+			t.Errorf("normalizeHost(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestKnownHostsLookupAcceptsTargets(t *testing.T) {
+	key := testEd25519Key(t)
+	kh := newTestKnownHosts(t, "")
+	if err := kh.Add([]string{"ssh://deploy@[::1]:2222"}, key); err != nil {
+		t.Fatal(err)
+	}
+	if content := readTestKnownHosts(t, kh); !strings.HasPrefix(content, "[::1]:2222 ") {
+		t.Fatalf("unexpected known_hosts content %q", content)
+	}
+	entries, err := kh.Lookup("root@[::1]:2222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected one entry, got %v", entries)
+	}
+}
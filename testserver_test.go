@@ -0,0 +1,77 @@
+package sshclient
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var errTestAuth = errors.New("test auth failed")
+
+type testSSHServer struct {
+	addr     string
+	listener net.Listener
+	config   *ssh.ServerConfig
+
+	handleConn func(conn *ssh.ServerConn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request)
+}
+
+func newTestSSHServer(t *testing.T, hostKeys ...ssh.Signer) *testSSHServer {
+	t.Helper()
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, errTestAuth
+		},
+	}
+	for _, key := range hostKeys {
+		config.AddHostKey(key)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testSSHServer{
+		addr:     listener.Addr().String(),
+		listener: listener,
+		config:   config,
+	}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	go s.serve()
+	return s
+}
+
+func (s *testSSHServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			sconn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			if s.handleConn != nil {
+				s.handleConn(sconn, chans, reqs)
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			for newChannel := range chans {
+				newChannel.Reject(ssh.Prohibited, "not supported by test server")
+			}
+		}()
+	}
+}
+
+func (s *testSSHServer) hostPort() (string, string) {
+	host, port, _ := net.SplitHostPort(s.addr)
+	return host, port
+}
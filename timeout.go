@@ -0,0 +1,145 @@
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultConnectTimeout   = 30 * time.Second
+	DefaultHandshakeTimeout = 30 * time.Second
+	DefaultSessionTimeout   = 30 * time.Second
+)
+
+// Phases reported by TimeoutError.
+const (
+	TimeoutPhaseConnect   = "connect"
+	TimeoutPhaseHandshake = "handshake"
+	TimeoutPhaseSession   = "session"
+)
+
+// TimeoutError reports which phase of setting up a connection or session
+// stalled. It satisfies net.Error with Timeout returning true.
+type TimeoutError struct {
+	Phase    string
+	Addr     string
+	Duration time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("ssh %s to %s timed out after %s", e.Phase, e.Addr, e.Duration)
+}
+
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+func (e *TimeoutError) Temporary() bool {
+	return true
+}
+
+// timeoutOrDefault maps the configured value to the effective one: zero
+// selects the default and a negative value disables the timeout.
+func timeoutOrDefault(timeout, def time.Duration) time.Duration {
+	if timeout == 0 {
+		return def
+	}
+	if timeout < 0 {
+		return 0
+	}
+	return timeout
+}
+
+func (c *Client) dialTimeout(ctx context.Context, addr string) (net.Conn, error) {
+	dial := c.dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	timeout := timeoutOrDefault(c.connectTimeout, DefaultConnectTimeout)
+	dialCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	conn, err := dial(dialCtx, "tcp", addr)
+	if err == nil {
+		return conn, nil
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+		return nil, &TimeoutError{Phase: TimeoutPhaseConnect, Addr: addr, Duration: timeout}
+	}
+	return nil, err
+}
+
+// runWithTimeout runs fn and gives up after timeout, in which case abort
+// is called to unblock fn and a TimeoutError for phase is returned.
+func runWithTimeout(phase, addr string, timeout time.Duration, abort func(), fn func() error) error {
+	return runWithPausableTimeout(phase, addr, timeout, abort, func(*phaseTimer) error {
+		return fn()
+	})
+}
+
+// runWithPausableTimeout is runWithTimeout where fn may stop the clock
+// while it waits on something other than the remote side.
+func runWithPausableTimeout(phase, addr string, timeout time.Duration, abort func(), fn func(timer *phaseTimer) error) error {
+	if timeout <= 0 {
+		return fn(nil)
+	}
+	timer := &phaseTimer{timer: time.NewTimer(timeout), remaining: timeout, started: time.Now()}
+	defer timer.timer.Stop()
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(timer)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.timer.C:
+		abort()
+		return &TimeoutError{Phase: phase, Addr: addr, Duration: timeout}
+	}
+}
+
+// phaseTimer is the clock of runWithPausableTimeout. A nil timer is never
+// running.
+type phaseTimer struct {
+	mu        sync.Mutex
+	timer     *time.Timer
+	remaining time.Duration
+	started   time.Time
+	paused    bool
+}
+
+func (t *phaseTimer) pause() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.paused && t.timer.Stop() {
+		t.paused = true
+		t.remaining -= time.Since(t.started)
+	}
+}
+
+func (t *phaseTimer) resume() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.paused {
+		t.paused = false
+		t.started = time.Now()
+		t.timer.Reset(max(t.remaining, 0))
+	}
+}
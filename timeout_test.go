@@ -0,0 +1,129 @@
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func assertTimeoutPhase(t *testing.T, err error, phase string) {
+	t.Helper()
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected a TimeoutError, got %v", err)
+	}
+	if timeoutErr.Phase != phase {
+		t.Fatalf("timeout phase = %s, want %s", timeoutErr.Phase, phase)
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("TimeoutError should be a net.Error timeout")
+	}
+}
+
+func TestDialConnectTimeout(t *testing.T) {
+	_, err := Dial(context.Background(), ClientConfig{
+		User:           "test",
+		Host:           "192.0.2.1",
+		SSHFolderPath:  t.TempDir(),
+		ConnectTimeout: 50 * time.Millisecond,
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			// A blackholed address never answers.
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}, WithGlobalKnownHostsFiles())
+	assertTimeoutPhase(t, err, TimeoutPhaseConnect)
+}
+
+func TestDialHandshakeTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		// Accept but never speak SSH.
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	_, err = Dial(context.Background(), ClientConfig{
+		User:             "test",
+		Password:         "secret",
+		Host:             listener.Addr().String(),
+		SSHFolderPath:    t.TempDir(),
+		HandshakeTimeout: 100 * time.Millisecond,
+	}, WithGlobalKnownHostsFiles())
+	assertTimeoutPhase(t, err, TimeoutPhaseHandshake)
+}
+
+func TestHandshakeTimeoutPausesForHostKeyApprover(t *testing.T) {
+	server := newTestSSHServer(t, testSigner(t))
+	client, err := Dial(context.Background(), ClientConfig{
+		User:             "test",
+		Password:         "secret",
+		Host:             server.addr,
+		SSHFolderPath:    t.TempDir(),
+		HandshakeTimeout: time.Second,
+	}, WithGlobalKnownHostsFiles(), WithHostKeyApprover(func(prompt HostKeyPrompt) (HostKeyDecision, error) {
+		// An operator takes longer to answer than the handshake may take.
+		time.Sleep(1500 * time.Millisecond)
+		return HostKeyAcceptOnce, nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.closeConnection()
+}
+
+// dialUnansweredSessions connects to a server that holds every channel
+// open request without answering it.
+func dialUnansweredSessions(t *testing.T) (*Client, <-chan ssh.NewChannel) {
+	server := newTestSSHServer(t, testSigner(t))
+	opened := make(chan ssh.NewChannel, 1)
+	server.handleConn = func(conn *ssh.ServerConn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request) {
+		go ssh.DiscardRequests(reqs)
+		for newChannel := range chans {
+			opened <- newChannel
+		}
+	}
+	client, err := Dial(context.Background(), ClientConfig{
+		User:           "test",
+		Password:       "secret",
+		Host:           server.addr,
+		SSHFolderPath:  t.TempDir(),
+		SessionTimeout: 100 * time.Millisecond,
+	}, WithGlobalKnownHostsFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.closeConnection)
+	return client, opened
+}
+
+func TestOpenSessionTimeout(t *testing.T) {
+	client, opened := dialUnansweredSessions(t)
+	_, err := client.openSession()
+	assertTimeoutPhase(t, err, TimeoutPhaseSession)
+	(<-opened).Reject(ssh.Prohibited, "too late")
+}
+
+func TestRunYesSessionTimeout(t *testing.T) {
+	client, opened := dialUnansweredSessions(t)
+	defer func() {
+		err, _ := recover().(error)
+		assertTimeoutPhase(t, err, TimeoutPhaseSession)
+		(<-opened).Reject(ssh.Prohibited, "too late")
+	}()
+	client.RunYes("true")
+}